@@ -1,9 +1,14 @@
 package main
 
 import (
+	"Tupyrae/internal/cli"
 	"Tupyrae/internal/controller"
+	"os"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] != "controller" {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
 	controller.Watcher()
 }
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Record is the audit entry of a container resource change
+type Record struct {
+	Time      time.Time   `json:"time"`
+	Workload  WorkloadRef `json:"workload"`
+	Container string      `json:"container"`
+
+	Before v1.ResourceRequirements `json:"before"`
+	After  v1.ResourceRequirements `json:"after"`
+
+	// Bounds are the recommendation the change was computed from, empty for OOM raises
+	LowerBound v1.ResourceList `json:"lowerBound,omitempty"`
+	Target     v1.ResourceList `json:"target,omitempty"`
+	UpperBound v1.ResourceList `json:"upperBound,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	Confidence float64         `json:"confidence,omitempty"`
+
+	// HourlyCostDelta is the change of the hourly cost of the whole workload, negative when saving
+	HourlyCostDelta float64 `json:"hourlyCostDelta,omitempty"`
+
+	Policy  map[string]string `json:"policy,omitempty"`
+	Mode    string            `json:"mode"`
+	Output  string            `json:"output"`
+	Result  string            `json:"result"`
+	Message string            `json:"message,omitempty"`
+}
+
+type WorkloadRef struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Logger writes the records as JSON lines
+type Logger struct {
+	out io.Writer
+	mu  sync.Mutex
+}
+
+// NewLogger writes to stdout when path is "-", to a file rotated past maxSize bytes otherwise
+func NewLogger(path string, maxSize int64, maxBackups int) (*Logger, error) {
+	if path == "-" {
+		return &Logger{out: os.Stdout}, nil
+	}
+
+	file, err := openRotating(path, maxSize, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{out: file}, nil
+}
+
+func (l *Logger) Log(record Record) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.out.Write(append(line, '\n'))
+	return err
+}
+
+// Parse reads JSON lines records
+func Parse(r io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		var record Record
+		if err := decoder.Decode(&record); err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
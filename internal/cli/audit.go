@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"Tupyrae/internal/audit"
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/handler"
+	"Tupyrae/internal/k8s"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runAudit lists the records of the audit ConfigMaps, or of an audit file
+func runAudit(args []string) int {
+	flags, kubeconfig := newFlagSet("audit")
+	namespace := flags.String("namespace", "", "Namespace of the records, all when empty")
+	workload := flags.String("workload", "", "Only the records of the workload, as kind/name")
+	since := flags.Duration("since", 0, "Only the records younger than the duration")
+	file := flags.String("file", "", "Read the records from an audit log file instead of the cluster")
+	configMap := flags.String("configmap", config.Get().AuditConfigMap, "Name of the audit ConfigMaps")
+	output := flags.String("output", "table", "Output format: table or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var records []audit.Record
+	var err error
+	if *file != "" {
+		records, err = fileRecords(*file)
+	} else {
+		if err := connect(*kubeconfig); err != nil {
+			return fail(os.Stderr, err)
+		}
+		records, err = configMapRecords(*namespace, *configMap)
+	}
+	if err != nil {
+		return fail(os.Stderr, err)
+	}
+
+	records = filterRecords(records, *namespace, *workload, *since)
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			encoder.Encode(record)
+		}
+	case "table":
+		printRecords(records)
+	default:
+		return fail(os.Stderr, fmt.Errorf("unknown output %q", *output))
+	}
+	return 0
+}
+
+func fileRecords(path string) ([]audit.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return audit.Parse(f)
+}
+
+func configMapRecords(namespace string, name string) ([]audit.Record, error) {
+	cms, err := k8s.GetConfigMaps(namespace, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var records []audit.Record
+	for _, cm := range cms {
+		if cm.Name != name {
+			continue
+		}
+		parsed, err := audit.Parse(strings.NewReader(cm.Data[handler.AuditKey]))
+		if err != nil {
+			return nil, fmt.Errorf("ConfigMap %s/%s: %v", cm.Namespace, cm.Name, err)
+		}
+		records = append(records, parsed...)
+	}
+	return records, nil
+}
+
+func filterRecords(records []audit.Record, namespace string, workload string, since time.Duration) []audit.Record {
+	var filtered []audit.Record
+	for _, r := range records {
+		if namespace != "" && r.Workload.Namespace != namespace {
+			continue
+		}
+		if workload != "" && !strings.EqualFold(workload, r.Workload.Kind+"/"+r.Workload.Name) {
+			continue
+		}
+		if since > 0 && time.Since(r.Time) > since {
+			continue
+		}
+		filtered = append(filtered, r)
+	}
+	return filtered
+}
+
+func printRecords(records []audit.Record) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNAMESPACE\tWORKLOAD\tCONTAINER\tREQUESTS\tLIMITS\tRESULT")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%s\t%s -> %s\t%s -> %s\t%s\n",
+			r.Time.Format(time.RFC3339), r.Workload.Namespace, r.Workload.Kind, r.Workload.Name, r.Container,
+			formatResources(r.Before.Requests), formatResources(r.After.Requests),
+			formatResources(r.Before.Limits), formatResources(r.After.Limits), r.Result)
+	}
+	w.Flush()
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"Tupyrae/internal/handler"
+	"Tupyrae/internal/k8s"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const usage = `Usage: tupyrae [command] [flags]
+
+Commands:
+  controller   Run the controller, the default without command
+  audit        List the resource changes recorded in the audit ConfigMaps
+  revert       Restore the resources of a workload before an adjustment and pause it
+  report       Print how the managed workloads are sized against their recommendation, whether Tupyrae
+               would adjust them and why not, or what they cost
+
+Run tupyrae <command> -h for the flags of a command.
+`
+
+// Run runs the command of the arguments and returns the exit code
+func Run(args []string) int {
+	// The policies of the workloads depend on the namespace selector
+	if err := handler.ParseNamespaceSelector(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch args[0] {
+	case "audit":
+		return runAudit(args[1:])
+	case "revert":
+		return runRevert(args[1:])
+	case "report":
+		return runReport(args[1:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return 0
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", args[0], usage)
+	return 2
+}
+
+// newFlagSet returns the flags of the command with the kubeconfig one
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	kubeconfig := flags.String("kubeconfig", "", "Path of the kubeconfig file, the default loading rules when empty")
+	return flags, kubeconfig
+}
+
+// parseFlags parses the flags wherever they are among the arguments and returns the other ones
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseWorkload reads a kind/name reference, the kind as kubectl names it
+func parseWorkload(ref string) (string, string, error) {
+	kind, name, ok := strings.Cut(ref, "/")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid workload %q, expected kind/name", ref)
+	}
+
+	kind, err := parseKind(kind)
+	return kind, name, err
+}
+
+// parseKind maps the kubectl names of the kinds to the kinds
+func parseKind(kind string) (string, error) {
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		return "Deployment", nil
+	case "cronjob", "cronjobs", "cj":
+		return "CronJob", nil
+	}
+	return "", fmt.Errorf("unsupported kind %q, expected deployment or cronjob", kind)
+}
+
+// connect points the clients to the cluster of the kubeconfig
+func connect(kubeconfig string) error {
+	k8s.UseKubeconfig(kubeconfig)
+	if k8s.GetClient() == nil {
+		return fmt.Errorf("cannot load the kubeconfig")
+	}
+	return nil
+}
+
+// formatResources prints the list as cpu=100m,memory=128Mi
+func formatResources(list v1.ResourceList) string {
+	if len(list) == 0 {
+		return "-"
+	}
+
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		q := list[v1.ResourceName(name)]
+		values = append(values, fmt.Sprintf("%s=%s", name, q.String()))
+	}
+	return strings.Join(values, ",")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func fail(out io.Writer, err error) int {
+	fmt.Fprintf(out, "Error: %v\n", err)
+	return 1
+}
@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"Tupyrae/internal/handler"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// hoursPerMonth is the average number of hours in a month, for the monthly costs
+const hoursPerMonth = 730
+
+// reportSorts order the workloads, the ties broken by namespace, kind and name
+var reportSorts = map[string]func(a, b handler.WorkloadReport) bool{
+	"namespace": func(a, b handler.WorkloadReport) bool { return a.Namespace < b.Namespace },
+	"kind":      func(a, b handler.WorkloadReport) bool { return a.Kind < b.Kind },
+	"name":      func(a, b handler.WorkloadReport) bool { return a.Name < b.Name },
+	// The most expensive and the most over-provisioned first, the biggest savings first
+	"cost":   func(a, b handler.WorkloadReport) bool { return a.HourlyCost > b.HourlyCost },
+	"delta":  func(a, b handler.WorkloadReport) bool { return a.HourlyCostDelta < b.HourlyCostDelta },
+	"cpu":    byProvisioning(v1.ResourceCPU),
+	"memory": byProvisioning(v1.ResourceMemory),
+}
+
+func byProvisioning(name v1.ResourceName) func(a, b handler.WorkloadReport) bool {
+	return func(a, b handler.WorkloadReport) bool {
+		pa, oka := a.Provisioning[name]
+		pb, okb := b.Provisioning[name]
+		if oka != okb {
+			return oka
+		}
+		return pa > pb
+	}
+}
+
+// fleetReport is the JSON output of the report command
+type fleetReport struct {
+	Workloads       []handler.WorkloadReport `json:"workloads"`
+	HourlyCost      float64                  `json:"hourlyCost"`
+	HourlyCostDelta float64                  `json:"hourlyCostDelta"`
+}
+
+// runReport evaluates the managed workloads with the decisions of the controller, configured by the
+// same TUPYRAE_* settings, and prints how they are sized or what they cost
+func runReport(args []string) int {
+	flags, kubeconfig := newFlagSet("report")
+	namespace := flags.String("namespace", "", "Namespace of the workloads, all when empty")
+	kind := flags.String("kind", "", "Kind of the workloads, deployment or cronjob, all when empty")
+	sortBy := flags.String("sort", "namespace", "Sort by namespace, kind, name, cost, delta, cpu or memory")
+	view := flags.String("view", "sizing", "Columns of the table and csv formats: sizing, per container, or cost")
+	format := flags.String("format", "table", "Output format: table, json or csv")
+	if _, err := parseFlags(flags, args); err != nil {
+		return 2
+	}
+	if *format != "table" && *format != "json" && *format != "csv" {
+		return fail(os.Stderr, fmt.Errorf("unknown format %q", *format))
+	}
+	if *view != "sizing" && *view != "cost" {
+		return fail(os.Stderr, fmt.Errorf("unknown view %q", *view))
+	}
+	less, ok := reportSorts[*sortBy]
+	if !ok {
+		return fail(os.Stderr, fmt.Errorf("unknown sort %q", *sortBy))
+	}
+	if *kind != "" {
+		k, err := parseKind(*kind)
+		if err != nil {
+			return fail(os.Stderr, err)
+		}
+		*kind = k
+	}
+	if err := connect(*kubeconfig); err != nil {
+		return fail(os.Stderr, err)
+	}
+
+	report := fleetReport{Workloads: handler.Report(*namespace, *kind)}
+	sort.SliceStable(report.Workloads, func(i, j int) bool {
+		a, b := report.Workloads[i], report.Workloads[j]
+		if less(a, b) != less(b, a) {
+			return less(a, b)
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	for _, w := range report.Workloads {
+		report.HourlyCost += w.HourlyCost
+		report.HourlyCostDelta += w.HourlyCostDelta
+	}
+
+	var err error
+	switch {
+	case *format == "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	case *format == "csv" && *view == "cost":
+		err = printCostCsv(report)
+	case *format == "csv":
+		err = printSizingCsv(report)
+	case *view == "cost":
+		printCostTable(report)
+	default:
+		printSizingTable(report)
+	}
+	if err != nil {
+		return fail(os.Stderr, err)
+	}
+	return 0
+}
+
+// decision tells in a few words if Tupyrae would adjust the workload, or why not
+func decision(r handler.WorkloadReport) string {
+	if r.WouldAdjust {
+		return "yes"
+	}
+	if r.Reason != "" {
+		return "no: " + r.Reason
+	}
+	return "no: " + r.State
+}
+
+func formatPercent(percents map[v1.ResourceName]float64, name v1.ResourceName) string {
+	percent, ok := percents[name]
+	if !ok {
+		return "-"
+	}
+	return strconv.FormatFloat(percent, 'f', 1, 64) + "%"
+}
+
+func printSizingTable(report fleetReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tCONTAINER\tREQUESTS\tLIMITS\tLOWER\tTARGET\tUPPER\tCPU\tMEMORY\tADJUST")
+	for _, r := range report.Workloads {
+		if len(r.Containers) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t-\t-\t-\t-\t-\t-\t-\t-\t%s\n", r.Namespace, r.Kind, r.Name, decision(r))
+		}
+		for i, c := range r.Containers {
+			namespace, kind, name, adjust := r.Namespace, r.Kind, r.Name, decision(r)
+			// The workload columns are only printed on its first container
+			if i > 0 {
+				namespace, kind, name, adjust = "", "", "", ""
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", namespace, kind, name, c.Name,
+				formatResources(c.Requests), formatResources(c.Limits),
+				formatResources(c.LowerBound), formatResources(c.Target), formatResources(c.UpperBound),
+				formatPercent(c.Provisioning, v1.ResourceCPU), formatPercent(c.Provisioning, v1.ResourceMemory), adjust)
+		}
+	}
+	w.Flush()
+}
+
+func printSizingCsv(report fleetReport) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"namespace", "kind", "name", "container", "requests", "limits", "lowerBound", "target", "upperBound",
+		"cpuProvisioning", "memoryProvisioning", "proposed", "wouldAdjust", "state", "reason", "message"})
+	for _, r := range report.Workloads {
+		for _, c := range r.Containers {
+			proposed := ""
+			if c.Proposed != nil {
+				proposed = fmt.Sprintf("requests %s limits %s", formatResources(c.Proposed.Requests), formatResources(c.Proposed.Limits))
+			}
+			w.Write([]string{r.Namespace, r.Kind, r.Name, c.Name,
+				formatResources(c.Requests), formatResources(c.Limits),
+				formatResources(c.LowerBound), formatResources(c.Target), formatResources(c.UpperBound),
+				formatPercent(c.Provisioning, v1.ResourceCPU), formatPercent(c.Provisioning, v1.ResourceMemory),
+				proposed, strconv.FormatBool(r.WouldAdjust), r.State, r.Reason, r.Message})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func printCostTable(report fleetReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tPOOL\tREPLICAS\tHOURLY COST\tADJUSTMENTS\tHOURLY DELTA\tMONTHLY DELTA\tPROPOSED DELTA")
+	for _, r := range report.Workloads {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\n", r.Namespace, r.Kind, r.Name, orDash(r.Pool), r.Replicas,
+			formatCost(r.HourlyCost), len(r.Adjustments), formatCost(r.HourlyCostDelta), formatCost(r.HourlyCostDelta*hoursPerMonth),
+			formatCost(r.ProposedHourlyCostDelta))
+	}
+	fmt.Fprintf(w, "TOTAL\t\t\t\t\t%s\t\t%s\t%s\t\n", formatCost(report.HourlyCost), formatCost(report.HourlyCostDelta),
+		formatCost(report.HourlyCostDelta*hoursPerMonth))
+	w.Flush()
+}
+
+func printCostCsv(report fleetReport) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"namespace", "kind", "name", "pool", "replicas", "hourlyCost", "adjustments", "hourlyCostDelta", "monthlyCostDelta",
+		"proposedHourlyCostDelta"})
+	for _, r := range report.Workloads {
+		w.Write([]string{r.Namespace, r.Kind, r.Name, r.Pool, strconv.FormatInt(r.Replicas, 10),
+			formatCost(r.HourlyCost), strconv.Itoa(len(r.Adjustments)), formatCost(r.HourlyCostDelta), formatCost(r.HourlyCostDelta * hoursPerMonth),
+			formatCost(r.ProposedHourlyCostDelta)})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func formatCost(cost float64) string {
+	return strconv.FormatFloat(cost, 'f', 4, 64)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"Tupyrae/internal/handler"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runRevert asks the controller to restore a revision of the history of the workload, the controller
+// commits it with its output and pauses the workload
+func runRevert(args []string) int {
+	flags, kubeconfig := newFlagSet("revert")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: tupyrae revert <kind>/<name> [flags]\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	namespace := flags.String("namespace", "default", "Namespace of the workload")
+	to := flags.Int("to", 1, "Revision to restore, 1 being the resources before the last adjustment")
+	list := flags.Bool("list", false, "List the revisions instead of reverting")
+	timeout := flags.Duration("timeout", time.Minute, "Time to wait for the controller to revert, 0 not to wait")
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	kind, name, err := parseWorkload(positional[0])
+	if err != nil {
+		return fail(os.Stderr, err)
+	}
+	if err := connect(*kubeconfig); err != nil {
+		return fail(os.Stderr, err)
+	}
+
+	if *list {
+		history, err := handler.History(*namespace, kind, name)
+		if err != nil {
+			return fail(os.Stderr, err)
+		}
+		printHistory(history)
+		return 0
+	}
+
+	_, previous, err := handler.RevertState(*namespace, kind, name)
+	if err != nil {
+		return fail(os.Stderr, err)
+	}
+	if err := handler.RequestRevert(*namespace, kind, name, *to); err != nil {
+		return fail(os.Stderr, err)
+	}
+	if *timeout == 0 {
+		fmt.Printf("Revert of %s %s/%s to revision %d requested\n", kind, *namespace, name, *to)
+		return 0
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(2 * time.Second)
+		pending, reason, err := handler.RevertState(*namespace, kind, name)
+		if err != nil {
+			return fail(os.Stderr, err)
+		}
+		if pending {
+			continue
+		}
+		if reason == "" || reason == previous {
+			return fail(os.Stderr, fmt.Errorf("the controller could not revert %s %s/%s, see its events", kind, *namespace, name))
+		}
+		fmt.Printf("%s %s/%s: %s, automatic adjustments paused until the tupyrae/paused annotation is removed\n", kind, *namespace, name, reason)
+		return 0
+	}
+	return fail(os.Stderr, fmt.Errorf("the controller did not revert %s %s/%s within %s", kind, *namespace, name, *timeout))
+}
+
+func printHistory(history []handler.Revision) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "REVISION\tTIME\tCONTAINER\tREQUESTS\tLIMITS")
+	for i, rev := range history {
+		for _, name := range sortedKeys(rev.Containers) {
+			r := rev.Containers[name]
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, rev.Time.Format(time.RFC3339), name, formatResources(r.Requests), formatResources(r.Limits))
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const prefix = "TUPYRAE_"
+
+type Config struct {
+	// OomMemoryFactor is applied to the memory request and limit of a container killed by OOM
+	OomMemoryFactor float64
+	// HpaPolicy decides what to do with workloads scaled by an HPA on CPU or memory: skip, exclude or warn
+	HpaPolicy string
+	// NodeMargin is the fraction of a node allocatable kept free when capping requests to fit the node
+	NodeMargin float64
+	// QosPolicy is preserve to keep the QoS class of the pods or allow to let adjustments change it
+	QosPolicy string
+	// InitContainersPolicy selects the init containers adjusted too: none, sidecars or all
+	InitContainersPolicy string
+	// NamespaceLabel is the namespace label holding the mode: off, recommend, apply or auto-vpa
+	NamespaceLabel string
+	// NamespaceSelector is the label selector of the namespaces managed, defaults to having NamespaceLabel
+	NamespaceSelector string
+	// DefaultMode is the mode of selected namespaces without NamespaceLabel
+	DefaultMode string
+	// Threshold is the relative difference between limits and recommendation that triggers an adjustment
+	Threshold float64
+	// Recommendation is the VPA recommendation field used for requests: lowerBound, target or upperBound
+	Recommendation string
+	// MaintenanceWindow restricts when adjustments are applied, e.g. "Mon-Fri 22:00-06:00", in UTC
+	MaintenanceWindow string
+	// Recommenders are the comma separated VPA recommenders used per workload kind, empty for the default one
+	Recommenders map[string]string
+	// RecommendationSource is where recommendations come from: vpa, prometheus or both comma separated
+	RecommendationSource string
+	// PrometheusURL is the base URL of the Prometheus compatible API
+	PrometheusURL string
+	// PrometheusWindow is the range of usage history the percentiles are computed on
+	PrometheusWindow string
+	// PrometheusInterval is the time between two evaluations of the workloads when the VPA is not a source
+	PrometheusInterval time.Duration
+	// Quantiles of the usage used as lower bound, target and upper bound of the recommendation
+	LowerQuantile  float64
+	TargetQuantile float64
+	UpperQuantile  float64
+	// MinConfidence is the confidence a recommendation needs before adjusting, 0 to adjust on any
+	MinConfidence float64
+	// MemoryMargin is added to the upper quantile of the memory usage used as limit, never below the peak
+	MemoryMargin float64
+	// CronJobStrategy is history to size CronJobs from their completed Jobs or vpa to apply the recommendation as is
+	CronJobStrategy string
+	// CronJobMinRuns is the number of completed runs needed before adjusting a CronJob or a Job group
+	CronJobMinRuns int
+	// CronJobMemoryMargin is added to the peak memory of the runs to size CronJobs and Job groups
+	CronJobMemoryMargin float64
+	// JobGroupLabel groups the standalone Jobs and bare Pods learnt from by its value, empty to disable
+	JobGroupLabel string
+	// Output is how adjustments reach the pods: update rolls the workload templates out, webhook
+	// injects the resources in the new pods at admission, resize resizes the running pods in place
+	// and gitops commits patches to a git working tree, all three leave the templates untouched
+	Output string
+	// WebhookPort is the port the admission webhook listens on
+	WebhookPort int
+	// WebhookService is the DNS name of the webhook Service the certificate is issued for
+	WebhookService string
+	// WebhookConfiguration is the MutatingWebhookConfiguration whose CA bundle is set at startup
+	WebhookConfiguration string
+	// WebhookSecret is the namespace/name of the Secret keeping the webhook CA and certificate
+	WebhookSecret string
+	// GitopsPath is the git working tree the gitops output commits the patches to
+	GitopsPath string
+	// GitopsBranch is the branch the patches are committed on, pushing it is left to another process
+	GitopsBranch string
+	// GitopsFormat renders kustomize strategic merge patches or helm values fragments
+	GitopsFormat string
+	// GitopsPathTemplate is the directory of the patch of each workload in the working tree, with
+	// {namespace}, {kind} and {name} placeholders
+	GitopsPathTemplate string
+	// GitopsAuthorName and GitopsAuthorEmail sign the commits
+	GitopsAuthorName  string
+	GitopsAuthorEmail string
+	// Approval requires a human to approve each adjustment through a TupyraeChange
+	Approval bool
+	// ApprovalTTL is the time after which a TupyraeChange not approved expires
+	ApprovalTTL time.Duration
+	// ApprovalRetention is the time the TupyraeChanges no longer pending are kept, 0 to keep them
+	ApprovalRetention time.Duration
+	// Status publishes the outcome of each evaluation in a TupyraeRecommendation per workload
+	Status bool
+	// HistorySize is the number of resource sets kept in the history annotation of the workloads, 0 to disable
+	HistorySize int
+	// CpuHourPrice and MemoryHourPrice are the prices of a vCPU-hour and a GiB-hour of requests
+	CpuHourPrice    float64
+	MemoryHourPrice float64
+	// PricePoolLabel is the node label telling the pool of the workloads, whose prices PoolPrices
+	// overrides as pool=cpu/memory,...
+	PricePoolLabel string
+	PoolPrices     string
+	// MetricsPort serves the Prometheus metrics, 0 to disable
+	MetricsPort int
+	// AuditLog is the file the audit records are written to, - for stdout, empty to disable
+	AuditLog string
+	// AuditMaxSize is the size in MB past which the audit file is rotated, keeping AuditMaxBackups
+	AuditMaxSize    int
+	AuditMaxBackups int
+	// AuditConfigMap keeps the last AuditConfigMapSize records of each namespace, 0 to disable
+	AuditConfigMap     string
+	AuditConfigMapSize int
+	// JobConfigMap is the ConfigMap of each namespace the Job group recommendations are written to
+	JobConfigMap string
+}
+
+var config *Config
+
+func Get() *Config {
+	if config == nil {
+		config = &Config{
+			OomMemoryFactor:      getFloat("OOM_MEMORY_FACTOR", 1.5),
+			HpaPolicy:            getString("HPA_POLICY", "exclude"),
+			NodeMargin:           getFloat("NODE_MARGIN", 0.1),
+			QosPolicy:            getString("QOS_POLICY", "preserve"),
+			InitContainersPolicy: getString("INIT_CONTAINERS_POLICY", "sidecars"),
+			NamespaceLabel:       getString("NAMESPACE_LABEL", "tupyrae"),
+			NamespaceSelector:    getString("NAMESPACE_SELECTOR", ""),
+			DefaultMode:          getString("DEFAULT_MODE", "apply"),
+			Threshold:            getFloat("THRESHOLD", 0.3),
+			Recommendation:       getString("RECOMMENDATION", "lowerBound"),
+			MaintenanceWindow:    getString("MAINTENANCE_WINDOW", ""),
+			RecommendationSource: getString("RECOMMENDATION_SOURCE", "vpa"),
+			PrometheusURL:        getString("PROMETHEUS_URL", "http://prometheus-server.monitoring.svc"),
+			PrometheusWindow:     getString("PROMETHEUS_WINDOW", "7d"),
+			PrometheusInterval:   getDuration("PROMETHEUS_INTERVAL", 5*time.Minute),
+			LowerQuantile:        getFloat("LOWER_QUANTILE", 0.5),
+			TargetQuantile:       getFloat("TARGET_QUANTILE", 0.9),
+			UpperQuantile:        getFloat("UPPER_QUANTILE", 0.99),
+			MemoryMargin:         getFloat("MEMORY_MARGIN", 0.15),
+			MinConfidence:        getFloat("MIN_CONFIDENCE", 0),
+			CronJobStrategy:      getString("CRONJOB_STRATEGY", "history"),
+			CronJobMinRuns:       getInt("CRONJOB_MIN_RUNS", 3),
+			CronJobMemoryMargin:  getFloat("CRONJOB_MEMORY_MARGIN", 0.2),
+			JobGroupLabel:        getString("JOB_GROUP_LABEL", ""),
+			JobConfigMap:         getString("JOB_CONFIGMAP", "tupyrae-job-recommendations"),
+			Output:               getString("OUTPUT", "update"),
+			WebhookPort:          getInt("WEBHOOK_PORT", 8443),
+			WebhookService:       getString("WEBHOOK_SERVICE", "tupyrae-webhook.tupyrae.svc"),
+			WebhookConfiguration: getString("WEBHOOK_CONFIGURATION", "tupyrae"),
+			WebhookSecret:        getString("WEBHOOK_SECRET", "tupyrae/tupyrae-webhook-tls"),
+			GitopsPath:           getString("GITOPS_PATH", "/gitops"),
+			GitopsBranch:         getString("GITOPS_BRANCH", "tupyrae"),
+			GitopsFormat:         getString("GITOPS_FORMAT", "kustomize"),
+			GitopsPathTemplate:   getString("GITOPS_PATH_TEMPLATE", "{namespace}/{name}"),
+			GitopsAuthorName:     getString("GITOPS_AUTHOR_NAME", "Tupyrae"),
+			GitopsAuthorEmail:    getString("GITOPS_AUTHOR_EMAIL", "tupyrae@localhost"),
+			Approval:             getString("APPROVAL", "false") == "true",
+			ApprovalTTL:          getDuration("APPROVAL_TTL", 24*time.Hour),
+			ApprovalRetention:    getDuration("APPROVAL_RETENTION", 7*24*time.Hour),
+			Status:               getString("STATUS", "true") == "true",
+			HistorySize:          getInt("HISTORY_SIZE", 5),
+			CpuHourPrice:         getFloat("CPU_HOUR_PRICE", 0.0316),
+			MemoryHourPrice:      getFloat("MEMORY_HOUR_PRICE", 0.0042),
+			PricePoolLabel:       getString("PRICE_POOL_LABEL", ""),
+			PoolPrices:           getString("POOL_PRICES", ""),
+			MetricsPort:          getInt("METRICS_PORT", 9090),
+			AuditLog:             getString("AUDIT_LOG", "-"),
+			AuditMaxSize:         getInt("AUDIT_MAX_SIZE", 10),
+			AuditMaxBackups:      getInt("AUDIT_MAX_BACKUPS", 5),
+			AuditConfigMap:       getString("AUDIT_CONFIGMAP", "tupyrae-audit"),
+			AuditConfigMapSize:   getInt("AUDIT_CONFIGMAP_SIZE", 0),
+			Recommenders: map[string]string{
+				"Deployment": getString("RECOMMENDERS_DEPLOYMENT", ""),
+				"CronJob":    getString("RECOMMENDERS_CRONJOB", ""),
+			},
+		}
+		if config.NamespaceSelector == "" {
+			config.NamespaceSelector = config.NamespaceLabel
+		}
+		// A factor of 1 or less would keep or lower the memory of a container killed by OOM
+		if !(config.OomMemoryFactor > 1) || math.IsInf(config.OomMemoryFactor, 0) {
+			klog.Errorf("Invalid value for %sOOM_MEMORY_FACTOR: %v, it must be above 1", prefix, config.OomMemoryFactor)
+			config.OomMemoryFactor = 1.5
+		}
+		if !(config.MemoryMargin >= 0) || math.IsInf(config.MemoryMargin, 0) {
+			klog.Errorf("Invalid value for %sMEMORY_MARGIN: %v, it must be positive", prefix, config.MemoryMargin)
+			config.MemoryMargin = 0.15
+		}
+	}
+	return config
+}
+
+func getString(name string, def string) string {
+	if v, ok := os.LookupEnv(prefix + name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func getFloat(name string, def float64) float64 {
+	v := getString(name, "")
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		klog.Errorf("Invalid value for %s%s: %v", prefix, name, err)
+		return def
+	}
+	return f
+}
+
+func getInt(name string, def int) int {
+	v := getString(name, "")
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		klog.Errorf("Invalid value for %s%s: %v", prefix, name, err)
+		return def
+	}
+	return i
+}
+
+func getDuration(name string, def time.Duration) time.Duration {
+	v := getString(name, "")
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		klog.Errorf("Invalid value for %s%s: %v", prefix, name, err)
+		return def
+	}
+	return d
+}
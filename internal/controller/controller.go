@@ -1,13 +1,18 @@
 package controller
 
 import (
+	"Tupyrae/internal/config"
 	"Tupyrae/internal/handler"
 	"Tupyrae/internal/k8s"
+	"Tupyrae/internal/metrics"
+	"Tupyrae/internal/webhook"
 	"context"
 	"fmt"
+	"maps"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
@@ -16,6 +21,7 @@ import (
 	runtimeobj "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	rt "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 	autoscalerv1 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
 	"k8s.io/client-go/tools/cache"
@@ -33,19 +39,41 @@ type ResourceWatcher struct {
 func Watcher() {
 	klog.Infof("Starting Controller...")
 
+	if err := handler.ParseNamespaceSelector(); err != nil {
+		klog.Fatalf("%v", err)
+	}
+
 	stop := make(chan bool)
 	ns := NsWatcher(stop)
-	vpa := VpaWatcher(stop)
-	// deploy := DeployWatcher(stop)
-	// cronjob := CronjobWatcher(stop)
+	pod := PodWatcher(stop)
+	deploy := DeployWatcher(stop)
+	cronjob := CronjobWatcher(stop)
 
 	stopCh := make(chan struct{})
 
 	ns.Watch(stopCh)
-	vpa.Watch(stopCh)
-
-	// go deploy.Watch(stopCh)
-	// go cronjob.Watch(stopCh)
+	if handler.UsesVpa() {
+		vpa := VpaWatcher(stop)
+		vpa.Watch(stopCh)
+	} else {
+		go wait.Until(handler.RecommenderRun, config.Get().PrometheusInterval, stopCh)
+	}
+	if config.Get().MetricsPort > 0 {
+		go metrics.Serve(config.Get().MetricsPort)
+	}
+	go wait.Until(handler.ChangeRun, time.Minute, stopCh)
+	if config.Get().JobGroupLabel != "" {
+		go wait.Until(handler.JobGroupRun, config.Get().PrometheusInterval, stopCh)
+	}
+	pod.Watch(stopCh)
+	deploy.Watch(stopCh)
+	cronjob.Watch(stopCh)
+
+	// The pods are admitted with the resources the Deployment and CronJob events remembered, the
+	// webhook only serves once they are all handled
+	if config.Get().Output == handler.OutputWebhook {
+		go webhook.Serve()
+	}
 
 	sigCh := make(chan os.Signal, 1)
 
@@ -61,10 +89,12 @@ func NsWatcher(stop <-chan bool) *ResourceWatcher {
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtimeobj.Object, error) {
 				klog.Infof("ListFunc NS...")
+				options.LabelSelector = handler.NamespaceSelector().String()
 				return clientset.CoreV1().Namespaces().List(context.Background(), options)
 			},
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
 				klog.Infof("WatchFunc NS...")
+				options.LabelSelector = handler.NamespaceSelector().String()
 				return clientset.CoreV1().Namespaces().Watch(context.Background(), options)
 			},
 		},
@@ -165,16 +195,63 @@ func VpaWatcher(stop <-chan bool) *ResourceWatcher {
 	}
 }
 
+func PodWatcher(stop <-chan bool) *ResourceWatcher {
+	klog.Infof("Starting PodWatcher...")
+
+	clientset := k8s.GetClient()
+	informer := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtimeobj.Object, error) {
+				return clientset.CoreV1().Pods("").List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return clientset.CoreV1().Pods("").Watch(context.Background(), options)
+			},
+		},
+		&corev1.Pod{},
+		0,
+		cache.Indexers{},
+	)
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+	return &ResourceWatcher{
+		clientset: clientset,
+		queue:     queue,
+		informer:  informer,
+	}
+}
+
+// Watch starts the informer and returns once the handlers processed the objects listed first
 func (watcher *ResourceWatcher) Watch(stopCh <-chan struct{}) {
 	klog.Infof("Starting watcher...")
 
 	defer watcher.queue.ShutDown()
 	defer rt.HandleCrash()
 
+	registration, err := watcher.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			enqueueResource("Add", obj)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if !workloadChanged(oldObj, newObj) {
+				return
+			}
+			enqueueResource("Update", newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			enqueueResource("Delete", obj)
+		},
+	})
+	if err != nil {
+		rt.HandleError(fmt.Errorf("error adding the event handler: %v", err))
+		return
+	}
+
 	go watcher.runWorker(stopCh)
 	go watcher.informer.Run(stopCh)
 
-	if !cache.WaitForCacheSync(stopCh, watcher.informer.HasSynced) {
+	if !cache.WaitForCacheSync(stopCh, watcher.informer.HasSynced, registration.HasSynced) {
 		rt.HandleError(fmt.Errorf("timeout waiting for cache sync"))
 		return
 	}
@@ -185,18 +262,6 @@ func (watcher *ResourceWatcher) Watch(stopCh <-chan struct{}) {
 func (rw *ResourceWatcher) runWorker(stopCh <-chan struct{}) {
 	defer runtime.HandleCrash()
 
-	rw.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			enqueueResource("Add", obj)
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			enqueueResource("Update", newObj)
-		},
-		DeleteFunc: func(obj interface{}) {
-			enqueueResource("Delete", obj)
-		},
-	})
-
 	go rw.informer.Run(stopCh)
 	if !cache.WaitForCacheSync(stopCh, rw.informer.HasSynced) {
 		klog.Fatalf("Fail to cache sync!")
@@ -205,6 +270,25 @@ func (rw *ResourceWatcher) runWorker(stopCh <-chan struct{}) {
 	<-stopCh
 }
 
+// workloadChanged tells if the update of a Deployment or a CronJob touches its spec, annotations or
+// labels, the status only updates being left out. The updates of the other kinds always count
+func workloadChanged(oldObj, newObj interface{}) bool {
+	switch newObj.(type) {
+	case *appsv1.Deployment, *batchv1.CronJob:
+	default:
+		return true
+	}
+
+	oldMeta, ok := oldObj.(metav1.Object)
+	newMeta, ok2 := newObj.(metav1.Object)
+	if !ok || !ok2 {
+		return true
+	}
+	return oldMeta.GetGeneration() != newMeta.GetGeneration() ||
+		!maps.Equal(oldMeta.GetAnnotations(), newMeta.GetAnnotations()) ||
+		!maps.Equal(oldMeta.GetLabels(), newMeta.GetLabels())
+}
+
 func enqueueResource(action string, obj interface{}) error {
 	if obj == nil {
 		return fmt.Errorf("Object is nil")
@@ -232,6 +316,10 @@ func enqueueResource(action string, obj interface{}) error {
 		resource.Kind = "VerticalPodAutoscaler"
 		resource.Name = vpa.Name
 		resource.Namespace = vpa.Namespace
+	} else if pod, ok := obj.(*corev1.Pod); ok {
+		resource.Kind = "Pod"
+		resource.Name = pod.Name
+		resource.Namespace = pod.Namespace
 	}
 
 	return handler.Checker(resource)
@@ -0,0 +1,121 @@
+package gitops
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Repo commits files to a branch of a local git working tree with the git CLI, pushing is left
+// to another process
+type Repo struct {
+	Path        string
+	Branch      string
+	AuthorName  string
+	AuthorEmail string
+
+	mu sync.Mutex
+}
+
+func NewRepo(path string, branch string, authorName string, authorEmail string) *Repo {
+	return &Repo{
+		Path:        path,
+		Branch:      branch,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+	}
+}
+
+// Commit writes the file, relative to the working tree, and commits it on the branch. It returns
+// false when the content was already committed
+func (r *Repo) Commit(file string, content []byte, message string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkout(); err != nil {
+		return false, err
+	}
+
+	path := filepath.Join(r.Path, filepath.Clean("/"+file))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return false, err
+	}
+
+	if _, err := r.git("add", "--", path); err != nil {
+		return false, err
+	}
+	// diff exits with 1 when the file is staged with changes, any other failure is an error
+	_, err := r.git("diff", "--cached", "--quiet", "--", path)
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.As(err, &exit) || exit.ExitCode() != 1:
+		return false, err
+	}
+	if _, err := r.git("commit", "--quiet", "-m", message, "--", path); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Read returns the content of the file, relative to the working tree, on the branch. It returns nil
+// when the file does not exist
+func (r *Repo) Read(file string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkout(); err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(r.Path, filepath.Clean("/"+file)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return content, err
+}
+
+// checkout switches to the branch, created from the current commit the first time
+func (r *Repo) checkout() error {
+	current, err := r.git("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return err
+	}
+	if current == r.Branch {
+		return nil
+	}
+
+	if _, err := r.git("rev-parse", "--verify", "--quiet", "refs/heads/"+r.Branch); err != nil {
+		_, err = r.git("checkout", "--quiet", "-b", r.Branch)
+		return err
+	}
+	_, err = r.git("checkout", "--quiet", r.Branch)
+	return err
+}
+
+func (r *Repo) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", r.Path}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+r.AuthorName,
+		"GIT_AUTHOR_EMAIL="+r.AuthorEmail,
+		"GIT_COMMITTER_NAME="+r.AuthorName,
+		"GIT_COMMITTER_EMAIL="+r.AuthorEmail,
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
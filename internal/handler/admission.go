@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// podOwner names the workload of the pod without API calls, the webhook having seconds to answer:
+// the ReplicaSets of a Deployment are named after it and the pod-template-hash label, the Jobs of a
+// CronJob after it and their scheduled time
+func podOwner(pod *v1.Pod) (string, string) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return "", ""
+	}
+
+	switch ref.Kind {
+	case "ReplicaSet":
+		if hash, ok := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; ok {
+			if name, found := strings.CutSuffix(ref.Name, "-"+hash); found {
+				return "Deployment", name
+			}
+		}
+	case "Job":
+		if i := strings.LastIndex(ref.Name, "-"); i > 0 {
+			if _, err := strconv.ParseUint(ref.Name[i+1:], 10, 64); err == nil {
+				return "CronJob", ref.Name[:i]
+			}
+		}
+	}
+	return "", ""
+}
+
+// patchOperation is a JSON patch operation of an admission response
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MutatePod returns the JSON patch setting the resources of the pod to the ones Tupyrae adjusted
+// for its workload, nil when the pod is left as is
+func MutatePod(pod *v1.Pod) ([]byte, error) {
+	w, resources := podApplied(pod)
+	if w == nil {
+		return nil, nil
+	}
+
+	var patch []patchOperation
+	for i, c := range pod.Spec.InitContainers {
+		if r, ok := resources[c.Name]; ok {
+			patch = append(patch, patchOperation{Op: "add", Path: fmt.Sprintf("/spec/initContainers/%d/resources", i), Value: r})
+		}
+	}
+	for i, c := range pod.Spec.Containers {
+		if r, ok := resources[c.Name]; ok {
+			patch = append(patch, patchOperation{Op: "add", Path: fmt.Sprintf("/spec/containers/%d/resources", i), Value: r})
+		}
+	}
+
+	if len(patch) == 0 {
+		return nil, nil
+	}
+
+	klog.Infof("Setting resources of a pod of %s %s/%s at admission", w.Kind, w.Namespace, w.Name)
+	return json.Marshal(patch)
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	ChangePending    = "Pending"
+	ChangeApplied    = "Applied"
+	ChangeExpired    = "Expired"
+	ChangeSuperseded = "Superseded"
+	ChangeOutdated   = "Outdated"
+	ChangeFailed     = "Failed"
+)
+
+// proposeChange records the adjustment of the workload as a TupyraeChange waiting for approval,
+// the pending changes of the workload it replaces are superseded. A change of the same adjustment
+// is kept as is, finished it is only proposed again once the retention removed it
+func proposeChange(w *Workload, original *v1.PodSpec, reason string) error {
+	change := &k8s.TupyraeChange{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: w.Namespace,
+			Labels:    map[string]string{ownerLabel: owner},
+		},
+		Spec: k8s.TupyraeChangeSpec{
+			WorkloadRef: k8s.WorkloadRef{APIVersion: w.APIVersion, Kind: w.Kind, Name: w.Name},
+			Reason:      reason,
+		},
+	}
+
+	before := specResources(original)
+	for _, c := range allContainers(w.PodSpec) {
+		if equality.Semantic.DeepEqual(before[c.Name], c.Resources) {
+			continue
+		}
+		change.Spec.Containers = append(change.Spec.Containers, k8s.ContainerChange{
+			Name:   c.Name,
+			Before: before[c.Name],
+			After:  *c.Resources.DeepCopy(),
+		})
+	}
+	change.Name = changeName(w, describeResources(w.PodSpec))
+
+	changes, err := k8s.GetChanges(w.Namespace)
+	if err != nil {
+		return err
+	}
+	var proposed *k8s.TupyraeChange
+	for i := range changes {
+		existing := &changes[i]
+		if existing.Spec.WorkloadRef != change.Spec.WorkloadRef {
+			continue
+		}
+		if existing.Name == change.Name {
+			proposed = existing
+			continue
+		}
+		if !isPending(existing) {
+			continue
+		}
+		finishChange(existing, ChangeSuperseded, fmt.Sprintf("Replaced by %s", change.Name))
+		if err := k8s.UpdateChangeStatus(existing); err != nil {
+			klog.Errorf("Error superseding TupyraeChange %s/%s: %v", existing.Namespace, existing.Name, err)
+		}
+	}
+
+	if proposed != nil {
+		if !isPending(proposed) {
+			klog.Infof("Adjustment of %s/%s already %s in TupyraeChange %s", w.Namespace, w.Name, proposed.Status.Phase, proposed.Name)
+		}
+		return nil
+	}
+	if err := k8s.CreateChange(change); err != nil {
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	recordEvent(w, v1.EventTypeNormal, "ApprovalRequested", fmt.Sprintf("TupyraeChange %s: %s", change.Name, reason))
+	return nil
+}
+
+// changeName is stable for a given adjustment of the workload
+func changeName(w *Workload, resources string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(resources))
+	name := fmt.Sprintf("%s-%s", strings.ToLower(w.Kind), w.Name)
+	if len(name) > 53 {
+		name = name[:53]
+	}
+	return fmt.Sprintf("%s-%08x", strings.TrimSuffix(name, "-"), hash.Sum32())
+}
+
+func isPending(change *k8s.TupyraeChange) bool {
+	return change.Status.Phase == "" || change.Status.Phase == ChangePending
+}
+
+// finishChange sets the phase of a change leaving Pending
+func finishChange(change *k8s.TupyraeChange, phase string, message string) {
+	now := metav1.Now()
+	change.Status.Phase = phase
+	change.Status.Message = message
+	change.Status.FinishedAt = &now
+	if phase == ChangeApplied {
+		change.Status.AppliedAt = &now
+	}
+}
+
+// isRetained tells if a change no longer pending is kept, the changes finished before FinishedAt
+// existed count from their creation
+func isRetained(change *k8s.TupyraeChange) bool {
+	retention := config.Get().ApprovalRetention
+	if retention <= 0 {
+		return true
+	}
+	finished := change.CreationTimestamp
+	if change.Status.FinishedAt != nil {
+		finished = *change.Status.FinishedAt
+	}
+	return time.Since(finished.Time) < retention
+}
+
+// ChangeRun periodically applies the approved TupyraeChanges, expires the stale ones and deletes the
+// ones finished for longer than the retention
+func ChangeRun() {
+	changes, err := k8s.GetChanges("")
+	if err != nil {
+		klog.Errorf("Error getting TupyraeChanges: %v", err)
+		return
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		if !isPending(change) {
+			if !isRetained(change) {
+				if err := k8s.DeleteChange(change.Namespace, change.Name); err != nil && !errors.IsNotFound(err) {
+					klog.Errorf("Error deleting TupyraeChange %s/%s: %v", change.Namespace, change.Name, err)
+				}
+			}
+			continue
+		}
+
+		phase, message := reviewChange(change)
+		if phase == change.Status.Phase && message == change.Status.Message {
+			continue
+		}
+		if phase == ChangePending {
+			change.Status.Phase = phase
+			change.Status.Message = message
+		} else {
+			finishChange(change, phase, message)
+		}
+		if err := k8s.UpdateChangeStatus(change); err != nil {
+			klog.Errorf("Error updating TupyraeChange %s/%s: %v", change.Namespace, change.Name, err)
+		}
+	}
+}
+
+// reviewChange applies the change once approved and returns its new phase. The workload must still
+// be managed in apply mode with approvals, and the change match the adjustment Tupyrae proposed
+func reviewChange(change *k8s.TupyraeChange) (string, string) {
+	if !change.Spec.Approved {
+		if time.Since(change.CreationTimestamp.Time) > config.Get().ApprovalTTL {
+			return ChangeExpired, "Not approved in time"
+		}
+		return ChangePending, "Waiting for approval"
+	}
+
+	ref := change.Spec.WorkloadRef
+	w, err := getWorkload(change.Namespace, ref.Kind, ref.Name)
+	if err != nil {
+		return ChangeFailed, err.Error()
+	}
+
+	policy := getPolicy(w)
+	switch {
+	case !isManaged(w):
+		return ChangeOutdated, fmt.Sprintf("%s %s is no longer managed", ref.Kind, ref.Name)
+	case policy.Mode != ModeApply:
+		return ChangeOutdated, fmt.Sprintf("%s %s is in %s mode", ref.Kind, ref.Name, policy.Mode)
+	case !policy.Approval:
+		return ChangeOutdated, fmt.Sprintf("%s %s no longer requires approvals", ref.Kind, ref.Name)
+	}
+	if reason, paused := isPaused(w); paused {
+		return ChangePending, "Approved, the workload is paused: " + reason
+	}
+
+	overlay(w)
+
+	for _, c := range change.Spec.Containers {
+		current := findContainer(containerList(w.PodSpec), c.Name)
+		if current == nil {
+			return ChangeOutdated, fmt.Sprintf("Container %s not found", c.Name)
+		}
+		if !equality.Semantic.DeepEqual(current.Resources, c.Before) {
+			return ChangeOutdated, fmt.Sprintf("Resources of container %s changed since the proposal", c.Name)
+		}
+	}
+
+	if open, err := inWindow(policy.MaintenanceWindow, time.Now()); err != nil || !open {
+		return ChangePending, "Approved, waiting for the maintenance window"
+	}
+
+	original := w.PodSpec.DeepCopy()
+	for _, c := range allContainers(w.PodSpec) {
+		for _, changed := range change.Spec.Containers {
+			if changed.Name == c.Name {
+				c.Resources = *changed.After.DeepCopy()
+			}
+		}
+	}
+
+	// The name hashes the proposed resources of every container, it no longer matches when the other
+	// containers changed since, or when the change was not proposed by Tupyrae
+	if changeName(w, describeResources(w.PodSpec)) != change.Name {
+		return ChangeOutdated, "The resources differ from the ones proposed"
+	}
+
+	ev := &evaluation{policy: policy, current: w.PodSpec}
+	defer publishStatus(w, ev)
+
+	klog.Infof("Applying approved TupyraeChange %s/%s: %s", change.Namespace, change.Name, describeResources(w.PodSpec))
+	changed, err := commitRevision(w, original)
+	if err != nil {
+		ev.set(StateFailed, ReasonOutput, err.Error())
+		ev.adjusted(ResultFailed, err.Error(), 0)
+		auditAdjustment(w, original, nil, policy, ResultFailed, err.Error())
+		return ChangeFailed, err.Error()
+	}
+	setCache(w.Namespace, w.Name)
+	if !changed {
+		ev.set(StateUpToDate, "", fmt.Sprintf("The %s output already has the resources", config.Get().Output))
+		return ChangeApplied, fmt.Sprintf("The %s output already had the resources", config.Get().Output)
+	}
+	delta := auditAdjustment(w, original, nil, policy, ResultSucceeded, fmt.Sprintf("TupyraeChange %s approved", change.Name))
+	ev.set(StateAdjusted, "", describeResources(w.PodSpec))
+	ev.adjusted(ResultSucceeded, fmt.Sprintf("approved TupyraeChange %s", change.Name), delta)
+	ev.cooldown = true
+	return ChangeApplied, fmt.Sprintf("Applied with the %s output", config.Get().Output)
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"Tupyrae/internal/audit"
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog"
+)
+
+// AuditKey is the key of the records in the audit ConfigMap of a namespace
+const AuditKey = "audit.jsonl"
+
+var auditLogger *audit.Logger
+
+func getAuditLogger() *audit.Logger {
+	cfg := config.Get()
+	if auditLogger == nil && cfg.AuditLog != "" {
+		logger, err := audit.NewLogger(cfg.AuditLog, int64(cfg.AuditMaxSize)*1024*1024, cfg.AuditMaxBackups)
+		if err != nil {
+			klog.Errorf("Error opening the audit log %s: %v", cfg.AuditLog, err)
+			return nil
+		}
+		auditLogger = logger
+	}
+	return auditLogger
+}
+
+// auditAdjustment records a change of each container whose resources differ from the original, and
+// returns the change of the hourly cost of the workload
+func auditAdjustment(w *Workload, original *v1.PodSpec, recommendations []Recommendation, policy Policy, result string, message string) float64 {
+	before := specResources(original)
+	now := time.Now().UTC()
+	delta := costDelta(w, original)
+	observeAdjustment(w, result, delta)
+
+	var records []audit.Record
+	for _, c := range allContainers(w.PodSpec) {
+		if equality.Semantic.DeepEqual(before[c.Name], c.Resources) {
+			continue
+		}
+
+		record := audit.Record{
+			Time:      now,
+			Workload:  audit.WorkloadRef{Kind: w.Kind, Namespace: w.Namespace, Name: w.Name},
+			Container: c.Name,
+			Before:    before[c.Name],
+			After:     *c.Resources.DeepCopy(),
+			Policy:    policyFields(policy),
+			Mode:      policy.Mode,
+			Output:    config.Get().Output,
+			Result:    result,
+			Message:   message,
+
+			HourlyCostDelta: roundCost(delta),
+		}
+		for _, r := range recommendations {
+			if r.ContainerName == c.Name {
+				record.LowerBound, record.Target, record.UpperBound = r.LowerBound, r.Target, r.UpperBound
+				record.Source, record.Confidence = r.Source, r.Confidence
+			}
+		}
+		records = append(records, record)
+	}
+
+	if logger := getAuditLogger(); logger != nil {
+		for _, record := range records {
+			if err := logger.Log(record); err != nil {
+				klog.Errorf("Error writing the audit log: %v", err)
+			}
+		}
+	}
+
+	if config.Get().AuditConfigMapSize > 0 && len(records) > 0 {
+		if err := appendAuditRecords(w.Namespace, records); err != nil {
+			klog.Errorf("Error writing the audit ConfigMap of %s: %v", w.Namespace, err)
+		}
+	}
+	return delta
+}
+
+func policyFields(policy Policy) map[string]string {
+	resources := make([]string, 0, len(policy.Resources))
+	for _, name := range policy.Resources {
+		resources = append(resources, string(name))
+	}
+
+	return map[string]string{
+		"resources":         strings.Join(resources, ","),
+		"values":            policy.Values,
+		"recommendation":    policy.Recommendation,
+		"threshold":         strconv.FormatFloat(policy.Threshold, 'f', -1, 64),
+		"hpa":               policy.Hpa,
+		"qos":               policy.Qos,
+		"maintenanceWindow": policy.MaintenanceWindow,
+		"approval":          strconv.FormatBool(policy.Approval),
+	}
+}
+
+// auditConfigMapBytes bounds the records kept in the ConfigMap, below the 1 MiB limit of an object
+const auditConfigMapBytes = 900 * 1024
+
+// appendAuditRecords adds the records to the ring buffer of the namespace, a ConfigMap keeping
+// the last AuditConfigMapSize records within auditConfigMapBytes. Concurrent adjustments of the
+// namespace write it too, the write is retried on conflict
+func appendAuditRecords(namespace string, records []audit.Record) error {
+	var lines []string
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, string(line))
+	}
+
+	conflict := func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, conflict, func() error {
+		return writeAuditLines(namespace, lines)
+	})
+}
+
+func writeAuditLines(namespace string, added []string) error {
+	cfg := config.Get()
+	cm, err := k8s.GetConfigMap(namespace, cfg.AuditConfigMap)
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cfg.AuditConfigMap,
+				Namespace: namespace,
+				Labels:    map[string]string{ownerLabel: owner},
+			},
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	if current := cm.Data[AuditKey]; current != "" {
+		lines = strings.Split(strings.TrimRight(current, "\n"), "\n")
+	}
+	lines = append(lines, added...)
+	if len(lines) > cfg.AuditConfigMapSize {
+		lines = lines[len(lines)-cfg.AuditConfigMapSize:]
+	}
+	size := 0
+	for _, line := range lines {
+		size += len(line) + 1
+	}
+	for len(lines) > 0 && size > auditConfigMapBytes {
+		size -= len(lines[0]) + 1
+		lines = lines[1:]
+	}
+
+	var data bytes.Buffer
+	for _, line := range lines {
+		data.WriteString(line)
+		data.WriteByte('\n')
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[AuditKey] = data.String()
+
+	if cm.ResourceVersion == "" {
+		_, err = k8s.CreateConfigMap(cm)
+	} else {
+		_, err = k8s.UpdateConfigMap(cm)
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"Tupyrae/internal/metrics"
+	"math"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// price is the hourly price of a vCPU and of a GiB of requests
+type price struct {
+	cpu    float64
+	memory float64
+}
+
+var poolPrices map[string]price
+
+var (
+	hourlyCostMetric = metrics.NewGauge("tupyrae_workload_hourly_cost",
+		"Hourly cost of the requests of the workload pods at the last evaluation.", "namespace", "kind", "workload")
+	costDeltaMetric = metrics.NewGauge("tupyrae_workload_hourly_cost_delta",
+		"Sum of the hourly cost deltas of the adjustments of the workload since the controller started, negative when saving.",
+		"namespace", "kind", "workload")
+	adjustmentsMetric = metrics.NewCounter("tupyrae_adjustments_total",
+		"Adjustments committed to the output.", "namespace", "kind", "workload", "result")
+)
+
+func init() {
+	metrics.NewGaugeFunc("tupyrae_fleet_hourly_cost", "Hourly cost of the requests of every managed workload.", hourlyCostMetric.Sum)
+	metrics.NewGaugeFunc("tupyrae_fleet_hourly_cost_delta",
+		"Sum of the hourly cost deltas of every adjustment since the controller started, negative when saving.", costDeltaMetric.Sum)
+}
+
+func getPoolPrices() map[string]price {
+	if poolPrices == nil {
+		poolPrices = parsePoolPrices(config.Get().PoolPrices)
+	}
+	return poolPrices
+}
+
+// parsePoolPrices reads the prices of the pools as pool=cpu/memory,...
+func parsePoolPrices(value string) map[string]price {
+	prices := map[string]price{}
+	for _, item := range splitList(value) {
+		pool, values, ok := strings.Cut(item, "=")
+		cpuValue, memoryValue, ok2 := strings.Cut(values, "/")
+		cpu, err := strconv.ParseFloat(strings.TrimSpace(cpuValue), 64)
+		memory, err2 := strconv.ParseFloat(strings.TrimSpace(memoryValue), 64)
+		if !ok || !ok2 || err != nil || err2 != nil {
+			klog.Errorf("Invalid pool price %q, expected pool=cpu/memory", item)
+			continue
+		}
+		prices[strings.TrimSpace(pool)] = price{cpu: cpu, memory: memory}
+	}
+	return prices
+}
+
+// workloadPool returns the node pool the pods are bound to by their node selector or a required
+// node affinity, empty when they may run anywhere
+func workloadPool(spec *v1.PodSpec) string {
+	label := config.Get().PricePoolLabel
+	if label == "" {
+		return ""
+	}
+	if pool, ok := spec.NodeSelector[label]; ok {
+		return pool
+	}
+
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expr := range term.MatchExpressions {
+			if expr.Key == label && expr.Operator == v1.NodeSelectorOpIn && len(expr.Values) == 1 {
+				return expr.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+func poolPrice(pool string) price {
+	if p, ok := getPoolPrices()[pool]; ok && pool != "" {
+		return p
+	}
+	return price{cpu: config.Get().CpuHourPrice, memory: config.Get().MemoryHourPrice}
+}
+
+// hourlyCost returns the cost of an hour of the requests of the workload pods, CronJobs are priced
+// while their Jobs run
+func hourlyCost(w *Workload, spec *v1.PodSpec) float64 {
+	replicas, _ := w.scale()
+	requests, _ := podResources(spec)
+	p := poolPrice(workloadPool(spec))
+
+	cpu := float64(requests.Cpu().MilliValue()) / 1000
+	memory := float64(requests.Memory().Value()) / (1 << 30)
+	return float64(replicas) * (cpu*p.cpu + memory*p.memory)
+}
+
+// costDelta returns the change of the hourly cost from the original resources to the current ones
+func costDelta(w *Workload, original *v1.PodSpec) float64 {
+	return hourlyCost(w, w.PodSpec) - hourlyCost(w, original)
+}
+
+// roundCost keeps the costs readable in the status and the reports
+func roundCost(cost float64) float64 {
+	return math.Round(cost*10000) / 10000
+}
+
+func costStatus(w *Workload, spec *v1.PodSpec) *k8s.CostStatus {
+	replicas, _ := w.scale()
+	return &k8s.CostStatus{
+		Pool:       workloadPool(spec),
+		Replicas:   replicas,
+		HourlyCost: roundCost(hourlyCost(w, spec)),
+	}
+}
+
+func observeCost(w *Workload, spec *v1.PodSpec) {
+	hourlyCostMetric.Set(hourlyCost(w, spec), w.Namespace, w.Kind, w.Name)
+}
+
+func observeAdjustment(w *Workload, result string, delta float64) {
+	adjustmentsMetric.Add(1, w.Namespace, w.Kind, w.Name, result)
+	if result == ResultSucceeded {
+		costDeltaMetric.Add(delta, w.Namespace, w.Kind, w.Name)
+	}
+}
+
+// forgetCost drops the samples of a deleted workload, the fleet cost only counts the existing ones
+func forgetCost(namespace string, kind string, name string) {
+	hourlyCostMetric.Delete(namespace, kind, name)
+}
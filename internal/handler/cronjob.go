@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// minRunDuration is the run length under which the usage of a run is barely sampled
+const minRunDuration = 2 * time.Minute
+
+// jobControllerLabel holds the UID of the Job on its pods
+const jobControllerLabel = "controller-uid"
+
+func CronJobRun(r Resource) error {
+	if _, ok := r.Item.(*batchv1.CronJob); !ok {
+		return fmt.Errorf("Item is not a CronJob")
+	}
+
+	if r.Action == "Delete" {
+		cronjob := r.Item.(*batchv1.CronJob)
+		forgetCost(cronjob.Namespace, "CronJob", cronjob.Name)
+		forgetApplied(cronjob.Namespace, "CronJob", cronjob.Name)
+		return nil
+	}
+
+	// The item belongs to the informer cache, adjustments work on a copy
+	cronjob := r.Item.(*batchv1.CronJob).DeepCopy()
+	w := workloadByCronJob(cronjob)
+	rememberApplied(w)
+	checkWorkload(w)
+
+	return nil
+}
+
+// jobHistory summarises the runs of a CronJob or a Job group still kept by the cluster
+type jobHistory struct {
+	// minRuns is the number of successful runs needed before sizing
+	minRuns   int
+	succeeded int
+	failed    int
+	durations []time.Duration
+	// oomKilled are the containers killed by OOM in a kept run
+	oomKilled []string
+}
+
+func (h *jobHistory) averageDuration() time.Duration {
+	if len(h.durations) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range h.durations {
+		total += d
+	}
+	return total / time.Duration(len(h.durations))
+}
+
+// addOomKilled records the containers of the pod terminated by OOM
+func (h *jobHistory) addOomKilled(pod *v1.Pod) {
+	for _, s := range pod.Status.ContainerStatuses {
+		terminated := s.State.Terminated
+		if terminated == nil {
+			terminated = s.LastTerminationState.Terminated
+		}
+		if terminated != nil && terminated.Reason == oomKilled && !hasString(h.oomKilled, s.Name) {
+			h.oomKilled = append(h.oomKilled, s.Name)
+		}
+	}
+}
+
+// ready tells if there are enough successful runs to learn from, or why not
+func (h *jobHistory) ready() (string, bool) {
+	if h.succeeded < h.minRuns {
+		return fmt.Sprintf("%d completed runs kept, %d needed before adjusting", h.succeeded, h.minRuns), false
+	}
+
+	if h.failed > h.succeeded {
+		return fmt.Sprintf("more failed runs (%d) than completed ones kept, not adjusting", h.failed), false
+	}
+	return "", true
+}
+
+// size sets the memory of the recommendations to the peak usage of the runs plus the margin,
+// batch workloads need their peak at each run. Without a peak the upper bound is the closest.
+// The confidence grows with the completed runs, halved when they are too short to be sampled
+func (h *jobHistory) size(recommendations []Recommendation, peaks map[string]resource.Quantity, containers []v1.Container) []Recommendation {
+	cfg := config.Get()
+	confidence := min(1, float64(h.succeeded)/float64(2*max(1, h.minRuns)))
+	if h.averageDuration() < minRunDuration {
+		confidence /= 2
+	}
+
+	for i := range recommendations {
+		rec := &recommendations[i]
+		peak, ok := peaks[rec.ContainerName]
+		if !ok {
+			upper, found := rec.UpperBound[v1.ResourceMemory]
+			if !found {
+				continue
+			}
+			peak = upper
+		}
+
+		// A run killed by OOM used more than its current memory
+		if c := findContainer(containers, rec.ContainerName); c != nil && hasString(h.oomKilled, rec.ContainerName) {
+			if killed := scaleQuantity(memoryOf(c.Resources), cfg.OomMemoryFactor); killed.Cmp(peak) > 0 {
+				peak = killed
+			}
+		}
+
+		// The lists may belong to a cached VPA, mergeResources works on copies
+		sized := scaleQuantity(peak, 1+cfg.CronJobMemoryMargin)
+		rec.LowerBound = mergeResources(rec.LowerBound, v1.ResourceList{v1.ResourceMemory: sized})
+		rec.Target = mergeResources(rec.Target, v1.ResourceList{v1.ResourceMemory: sized})
+		if upper, found := rec.UpperBound[v1.ResourceMemory]; !found || upper.Cmp(sized) < 0 {
+			rec.UpperBound = mergeResources(rec.UpperBound, v1.ResourceList{v1.ResourceMemory: sized})
+		}
+		rec.Confidence = min(rec.Confidence, confidence)
+		rec.Source += "/history"
+	}
+
+	return recommendations
+}
+
+// ownedJobs returns the Jobs created by the CronJob
+func ownedJobs(w *Workload) ([]batchv1.Job, error) {
+	jobs, err := k8s.GetJobs(w.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []batchv1.Job
+	for _, job := range jobs {
+		if ref := metav1.GetControllerOf(&job); ref != nil && ref.UID == w.Meta.UID {
+			owned = append(owned, job)
+		}
+	}
+	return owned, nil
+}
+
+// jobPods returns the pods of the Jobs, listed by the controller-uid label the Job controller sets
+func jobPods(namespace string, jobs []batchv1.Job) ([]v1.Pod, error) {
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+
+	uids := map[types.UID]bool{}
+	values := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		uids[job.UID] = true
+		values = append(values, string(job.UID))
+	}
+
+	selector, err := labels.NewRequirement(jobControllerLabel, selection.In, values)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k8s.GetPods(namespace, selector.String())
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []v1.Pod
+	for _, pod := range pods {
+		if ref := metav1.GetControllerOf(&pod); ref != nil && uids[ref.UID] {
+			owned = append(owned, pod)
+		}
+	}
+	return owned, nil
+}
+
+// cronjobMinRuns clamps the runs needed to the successful Jobs the CronJob keeps, it could never reach
+// more. A CronJob keeping none still needs one
+func cronjobMinRuns(w *Workload) int {
+	minRuns := config.Get().CronJobMinRuns
+	if cronjob, ok := w.Item.(*batchv1.CronJob); ok {
+		limit := 3
+		if cronjob.Spec.SuccessfulJobsHistoryLimit != nil {
+			limit = int(*cronjob.Spec.SuccessfulJobsHistoryLimit)
+		}
+		minRuns = min(minRuns, limit)
+	}
+	return max(1, minRuns)
+}
+
+func cronjobHistory(w *Workload) (*jobHistory, error) {
+	jobs, err := ownedJobs(w)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &jobHistory{minRuns: cronjobMinRuns(w)}
+	for _, job := range jobs {
+		for _, c := range job.Status.Conditions {
+			if c.Status != v1.ConditionTrue {
+				continue
+			}
+			switch c.Type {
+			case batchv1.JobComplete:
+				history.succeeded++
+				if job.Status.StartTime != nil && job.Status.CompletionTime != nil {
+					history.durations = append(history.durations, job.Status.CompletionTime.Sub(job.Status.StartTime.Time))
+				}
+			case batchv1.JobFailed:
+				history.failed++
+			}
+		}
+	}
+
+	pods, err := jobPods(w.Namespace, jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range pods {
+		history.addOomKilled(&pods[i])
+	}
+
+	return history, nil
+}
+
+// cronjobRecommender sizes CronJobs from the history of their Jobs: it waits for enough completed
+// runs and sizes memory on the peak usage of the runs
+type cronjobRecommender struct {
+	base Recommender
+}
+
+func (r *cronjobRecommender) Name() string {
+	return fmt.Sprintf("cronjob(%s)", r.base.Name())
+}
+
+func (r *cronjobRecommender) Recommend(w *Workload) ([]Recommendation, error) {
+	history, err := cronjobHistory(w)
+	if err != nil {
+		return nil, err
+	}
+
+	if message, ok := history.ready(); !ok {
+		recordEventOnce(w, v1.EventTypeNormal, "InsufficientHistory", message)
+		return nil, nil
+	}
+
+	recommendations, err := r.base.Recommend(w)
+	if err != nil {
+		return nil, err
+	}
+
+	peaks := map[string]resource.Quantity{}
+	if usesSource(SourcePrometheus) {
+		if peaks, err = peakMemory(getPrometheus(), podSelector(w)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Without the usage of the runs, memory is sized on the upper bound of the base recommender
+	policy := getPolicy(w)
+	var missing []string
+	for _, rec := range recommendations {
+		if _, ok := peaks[rec.ContainerName]; !ok && !policy.isIgnoredContainer(rec.ContainerName) {
+			missing = append(missing, rec.ContainerName)
+		}
+	}
+	if len(missing) > 0 {
+		message := fmt.Sprintf("no peak memory usage of %s, sized on the upper bound of %s", strings.Join(missing, ","), r.base.Name())
+		klog.Infof("CronJob %s/%s: %s", w.Namespace, w.Name, message)
+		recordEventOnce(w, v1.EventTypeNormal, "PeakUnavailable", message)
+	}
+
+	return history.size(recommendations, peaks, containerList(w.PodSpec)), nil
+}
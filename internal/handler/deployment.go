@@ -1,2 +1,28 @@
 package handler
 
+import (
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+func DeployRun(r Resource) error {
+	if _, ok := r.Item.(*appsv1.Deployment); !ok {
+		return fmt.Errorf("Item is not a Deployment")
+	}
+
+	if r.Action == "Delete" {
+		deploy := r.Item.(*appsv1.Deployment)
+		forgetCost(deploy.Namespace, "Deployment", deploy.Name)
+		forgetApplied(deploy.Namespace, "Deployment", deploy.Name)
+		return nil
+	}
+
+	// The item belongs to the informer cache, adjustments work on a copy
+	deploy := r.Item.(*appsv1.Deployment).DeepCopy()
+	w := workloadByDeployment(deploy)
+	rememberApplied(w)
+	checkWorkload(w)
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"Tupyrae/internal/k8s"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// dryRun only logs the events, for the report command evaluating the workloads from outside the controller
+var dryRun bool
+
+// eventCache holds the events recorded by recordEventOnce recently
+var eventCache = cache.New(DefaultExpiration, 30*time.Minute)
+
+// recordEvent logs the message and records it as an Event on the workload
+func recordEvent(w *Workload, eventType string, reason string, message string) {
+	klog.Infof("%s %s/%s: %s: %s", w.Kind, w.Namespace, w.Name, reason, message)
+	if dryRun {
+		return
+	}
+
+	ref := &v1.ObjectReference{
+		APIVersion: w.APIVersion,
+		Kind:       w.Kind,
+		Name:       w.Name,
+		Namespace:  w.Namespace,
+		UID:        w.Meta.UID,
+	}
+	if _, err := k8s.CreateEvent(ref, eventType, reason, message); err != nil {
+		klog.Errorf("Error creating Event for %s/%s: %v", w.Namespace, w.Name, err)
+	}
+}
+
+// recordEventOnce records the event unless the same one was recorded on the workload recently, for the
+// conditions found again at each evaluation
+func recordEventOnce(w *Workload, eventType string, reason string, message string) {
+	key := strings.Join([]string{w.Namespace, w.Kind, w.Name, reason, message}, "/")
+	if _, found := eventCache.Get(key); found {
+		return
+	}
+	eventCache.Set(key, true, cache.DefaultExpiration)
+	recordEvent(w, eventType, reason, message)
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/gitops"
+	"fmt"
+	"path"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// GitopsFormatKustomize renders a strategic merge patch of the workload
+	GitopsFormatKustomize = "kustomize"
+	// GitopsFormatHelm renders a values fragment with the resources of each container
+	GitopsFormatHelm = "helm"
+)
+
+const (
+	kustomizeFile = "tupyrae-patch.yaml"
+	helmFile      = "tupyrae-values.yaml"
+)
+
+var gitopsRepo *gitops.Repo
+
+func getGitopsRepo() *gitops.Repo {
+	if gitopsRepo == nil {
+		cfg := config.Get()
+		gitopsRepo = gitops.NewRepo(cfg.GitopsPath, cfg.GitopsBranch, cfg.GitopsAuthorName, cfg.GitopsAuthorEmail)
+	}
+	return gitopsRepo
+}
+
+// commitPatch renders the adjusted resources of the workload and commits them to the GitOps
+// repository, the cluster gets them at the next sync. It returns false when the repository already
+// had them
+func commitPatch(w *Workload) (bool, error) {
+	file, content, err := renderPatch(w, config.Get().GitopsFormat)
+	if err != nil {
+		return false, err
+	}
+
+	dir := gitopsDir(getPolicy(w).GitopsPath, w)
+	message := fmt.Sprintf("Resize %s %s/%s\n\n%s", w.Kind, w.Namespace, w.Name, describeResources(w.PodSpec))
+	committed, err := getGitopsRepo().Commit(path.Join(dir, file), content, message)
+	if err != nil {
+		return false, err
+	}
+	if committed {
+		klog.Infof("Committed the resources of %s %s/%s to %s", w.Kind, w.Namespace, w.Name, path.Join(dir, file))
+	}
+	return committed, nil
+}
+
+// committedResources reads the resources of the patch committed for the workload, nil when there is
+// none. The template only gets them once the GitOps tool synced the patch
+func committedResources(w *Workload) map[string]v1.ResourceRequirements {
+	format := config.Get().GitopsFormat
+	file := path.Join(gitopsDir(getPolicy(w).GitopsPath, w), patchFile(format))
+	content, err := getGitopsRepo().Read(file)
+	if err != nil || content == nil {
+		if err != nil {
+			klog.Errorf("Error reading the patch of %s %s/%s: %v", w.Kind, w.Namespace, w.Name, err)
+		}
+		return nil
+	}
+
+	resources, err := parsePatch(w, format, content)
+	if err != nil {
+		klog.Errorf("Invalid patch %s of %s %s/%s: %v", file, w.Kind, w.Namespace, w.Name, err)
+		return nil
+	}
+	return resources
+}
+
+// patchFile returns the name of the patch file in the format
+func patchFile(format string) string {
+	if format == GitopsFormatHelm {
+		return helmFile
+	}
+	return kustomizeFile
+}
+
+// parsePatch reads the resources of the containers back from a patch rendered by renderPatch
+func parsePatch(w *Workload, format string, content []byte) (map[string]v1.ResourceRequirements, error) {
+	switch format {
+	case GitopsFormatKustomize:
+		var spec *v1.PodSpec
+		switch w.Kind {
+		case "Deployment":
+			var deploy appsv1.Deployment
+			if err := yaml.Unmarshal(content, &deploy); err != nil {
+				return nil, err
+			}
+			spec = &deploy.Spec.Template.Spec
+		case "CronJob":
+			var cronjob batchv1.CronJob
+			if err := yaml.Unmarshal(content, &cronjob); err != nil {
+				return nil, err
+			}
+			spec = &cronjob.Spec.JobTemplate.Spec.Template.Spec
+		default:
+			return nil, fmt.Errorf("Unsupported kind: %s", w.Kind)
+		}
+		return specResources(spec), nil
+	case GitopsFormatHelm:
+		var values struct {
+			Containers map[string]struct {
+				Resources v1.ResourceRequirements `json:"resources"`
+			} `json:"containers"`
+		}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, err
+		}
+		resources := map[string]v1.ResourceRequirements{}
+		for name, c := range values.Containers {
+			resources[name] = c.Resources
+		}
+		return resources, nil
+	}
+	return nil, fmt.Errorf("unknown GitOps format %q", format)
+}
+
+// gitopsDir expands the {namespace}, {kind} and {name} placeholders of the path template
+func gitopsDir(template string, w *Workload) string {
+	return strings.NewReplacer(
+		"{namespace}", w.Namespace,
+		"{kind}", strings.ToLower(w.Kind),
+		"{name}", w.Name,
+	).Replace(template)
+}
+
+// renderPatch returns the file name and content of the patch in the format
+func renderPatch(w *Workload, format string) (string, []byte, error) {
+	header := fmt.Sprintf("# Resources of %s %s/%s, generated by Tupyrae\n", w.Kind, w.Namespace, w.Name)
+
+	switch format {
+	case GitopsFormatKustomize:
+		content, err := yaml.Marshal(kustomizePatch(w))
+		if err != nil {
+			return "", nil, err
+		}
+		return kustomizeFile, append([]byte(header), content...), nil
+	case GitopsFormatHelm:
+		content, err := yaml.Marshal(helmValues(w))
+		if err != nil {
+			return "", nil, err
+		}
+		return helmFile, append([]byte(header), content...), nil
+	}
+	return "", nil, fmt.Errorf("unknown GitOps format %q", format)
+}
+
+// kustomizePatch is a strategic merge patch setting the resources of the containers
+func kustomizePatch(w *Workload) map[string]interface{} {
+	podSpec := map[string]interface{}{}
+	if containers := patchContainers(w.PodSpec.InitContainers); len(containers) > 0 {
+		podSpec["initContainers"] = containers
+	}
+	if containers := patchContainers(w.PodSpec.Containers); len(containers) > 0 {
+		podSpec["containers"] = containers
+	}
+
+	template := map[string]interface{}{"spec": podSpec}
+	spec := map[string]interface{}{"template": template}
+	if w.Kind == "CronJob" {
+		spec = map[string]interface{}{"jobTemplate": map[string]interface{}{"spec": spec}}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": w.APIVersion,
+		"kind":       w.Kind,
+		"metadata": map[string]interface{}{
+			"name":      w.Name,
+			"namespace": w.Namespace,
+		},
+		"spec": spec,
+	}
+}
+
+func patchContainers(containers []v1.Container) []map[string]interface{} {
+	var patch []map[string]interface{}
+	for _, c := range containers {
+		if len(c.Resources.Requests) == 0 && len(c.Resources.Limits) == 0 {
+			continue
+		}
+		patch = append(patch, map[string]interface{}{"name": c.Name, "resources": c.Resources})
+	}
+	return patch
+}
+
+// helmValues keys the resources by container name, the resources of a single container are at
+// the top level too, where most charts read them
+func helmValues(w *Workload) map[string]interface{} {
+	containers := map[string]interface{}{}
+	for name, r := range specResources(w.PodSpec) {
+		if len(r.Requests) == 0 && len(r.Limits) == 0 {
+			continue
+		}
+		containers[name] = map[string]interface{}{"resources": r}
+	}
+
+	values := map[string]interface{}{"containers": containers}
+	if len(w.PodSpec.Containers) == 1 {
+		values["resources"] = w.PodSpec.Containers[0].Resources
+	}
+	return values
+}
@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// historyKey holds the resources of the containers before the last adjustments, gzipped JSON in base64
+	historyKey = "tupyrae/history"
+	// revertKey asks the controller to restore a revision of the history, 1 being the most recent
+	revertKey = "tupyrae/revert"
+	// pausedKey stops the automatic adjustments of the workload until removed, its value tells why
+	pausedKey = "tupyrae/paused"
+)
+
+// Revision is the resources of the containers before an adjustment
+type Revision struct {
+	Time       time.Time                          `json:"time"`
+	Containers map[string]v1.ResourceRequirements `json:"containers"`
+}
+
+// readHistory decodes the history annotation, the most recent revision first
+func readHistory(meta *metav1.ObjectMeta) ([]Revision, error) {
+	value, ok := meta.Annotations[historyKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var history []Revision
+	if err := decodeAnnotation(value, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// decodeAnnotation reads an annotation holding gzipped JSON in base64
+func decodeAnnotation(value string, v interface{}) error {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return err
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// encodeAnnotation writes the value as gzipped JSON in base64, keeping the annotations holding
+// resources small
+func encodeAnnotation(v interface{}) (string, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	var data bytes.Buffer
+	writer := gzip.NewWriter(&data)
+	if _, err := writer.Write(raw); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data.Bytes()), nil
+}
+
+// historyAnnotations adds the original resources in front of the history of the workload, keeping
+// the last HistorySize revisions
+func historyAnnotations(w *Workload, original *v1.PodSpec) map[string]*string {
+	size := config.Get().HistorySize
+	if size <= 0 {
+		return nil
+	}
+
+	history, err := readHistory(w.Meta)
+	if err != nil {
+		klog.Errorf("Invalid %s of %s %s/%s, starting a new one: %v", historyKey, w.Kind, w.Namespace, w.Name, err)
+		history = nil
+	}
+	history = append([]Revision{{Time: time.Now().UTC(), Containers: specResources(original)}}, history...)
+	if len(history) > size {
+		history = history[:size]
+	}
+
+	value, err := encodeAnnotation(history)
+	if err != nil {
+		klog.Errorf("Error encoding the %s of %s %s/%s: %v", historyKey, w.Kind, w.Namespace, w.Name, err)
+		return nil
+	}
+	return map[string]*string{historyKey: &value}
+}
+
+// commitRevision commits the adjustment, keeping the original resources in the history of the workload.
+// It returns false when the output already had the resources, the history is left as is then
+func commitRevision(w *Workload, original *v1.PodSpec) (bool, error) {
+	return commitAnnotated(w, historyAnnotations(w, original))
+}
+
+// commitAnnotated commits the adjustment and sets the annotations of the workload, nil values
+// removing them. The update output writes them along with the template, the others patch the workload
+// once committed. It returns false when the output already had the resources, without annotating
+func commitAnnotated(w *Workload, annotations map[string]*string) (bool, error) {
+	if config.Get().Output == OutputUpdate {
+		setAnnotations(w.Meta, annotations)
+		return true, w.Update()
+	}
+
+	changed, err := commit(w)
+	if err != nil || !changed {
+		return false, err
+	}
+	if err := w.annotate(annotations); err != nil {
+		klog.Errorf("Error annotating %s %s/%s: %v", w.Kind, w.Namespace, w.Name, err)
+	}
+	return true, nil
+}
+
+func setAnnotations(meta *metav1.ObjectMeta, annotations map[string]*string) {
+	for key, value := range annotations {
+		if value == nil {
+			delete(meta.Annotations, key)
+			continue
+		}
+		if meta.Annotations == nil {
+			meta.Annotations = map[string]string{}
+		}
+		meta.Annotations[key] = *value
+	}
+}
+
+// annotate patches the annotations of the workload, without touching its template
+func (w *Workload) annotate(annotations map[string]*string) error {
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+
+	switch w.Item.(type) {
+	case *appsv1.Deployment:
+		_, err = k8s.PatchDeploy(w.Namespace, w.Name, patch)
+	case *batchv1.CronJob:
+		_, err = k8s.PatchCronJob(w.Namespace, w.Name, patch)
+	default:
+		return fmt.Errorf("Unsupported kind: %s", w.Kind)
+	}
+	if err != nil {
+		return err
+	}
+	setAnnotations(w.Meta, annotations)
+	return nil
+}
+
+func isPaused(w *Workload) (string, bool) {
+	reason, ok := w.Meta.Annotations[pausedKey]
+	return reason, ok
+}
+
+// parseRevision reads the revision of the revert annotation, the most recent one when empty
+func parseRevision(value string) (int, error) {
+	if value == "" || value == "true" {
+		return 1, nil
+	}
+	revision, err := strconv.Atoi(value)
+	if err != nil || revision < 1 {
+		return 0, fmt.Errorf("invalid revision %q", value)
+	}
+	return revision, nil
+}
+
+// revertWorkload restores the revision asked by the revert annotation and pauses the workload, the
+// annotation is removed either way
+func revertWorkload(w *Workload) {
+	annotations := map[string]*string{revertKey: nil}
+
+	revision, err := parseRevision(w.Meta.Annotations[revertKey])
+	if err == nil {
+		var message string
+		message, err = revert(w, revision, annotations)
+		if err == nil {
+			recordEvent(w, v1.EventTypeNormal, "Reverted", message)
+			return
+		}
+	}
+
+	recordEvent(w, v1.EventTypeWarning, "RevertFailed", err.Error())
+	if err := w.annotate(annotations); err != nil {
+		klog.Errorf("Error removing %s of %s %s/%s: %v", revertKey, w.Kind, w.Namespace, w.Name, err)
+	}
+}
+
+// revert commits the resources of the revision with the annotations, pausing the workload
+func revert(w *Workload, revision int, annotations map[string]*string) (string, error) {
+	history, err := readHistory(w.Meta)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", historyKey, err)
+	}
+	if revision > len(history) {
+		return "", fmt.Errorf("revision %d not found, the history has %d", revision, len(history))
+	}
+	rev := history[revision-1]
+
+	overlay(w)
+	original := w.PodSpec.DeepCopy()
+	injectResources(w.PodSpec, rev.Containers)
+
+	message := fmt.Sprintf("Reverted to revision %d from %s at %s", revision, rev.Time.Format(time.RFC3339), time.Now().UTC().Format(time.RFC3339))
+	annotations[pausedKey] = &message
+
+	policy := getPolicy(w)
+	ev := &evaluation{policy: policy, current: w.PodSpec}
+	ev.set(StateBlocked, ReasonPaused, message)
+	defer publishStatus(w, ev)
+
+	klog.Infof("Reverting %s %s/%s to revision %d: %s", w.Kind, w.Namespace, w.Name, revision, describeResources(w.PodSpec))
+	if equality.Semantic.DeepEqual(original, w.PodSpec) {
+		return message, w.annotate(annotations)
+	}
+	changed, err := commitAnnotated(w, annotations)
+	if err != nil {
+		delete(annotations, pausedKey)
+		ev.set(StateFailed, ReasonOutput, err.Error())
+		ev.adjusted(ResultFailed, err.Error(), 0)
+		auditAdjustment(w, original, nil, policy, ResultFailed, err.Error())
+		return "", err
+	}
+	// The output already had the revision, only the annotations are left to set
+	if !changed {
+		return message, w.annotate(annotations)
+	}
+	setCache(w.Namespace, w.Name)
+	delta := auditAdjustment(w, original, nil, policy, ResultSucceeded, message)
+	ev.adjusted(ResultSucceeded, message, delta)
+	return message, nil
+}
+
+// History returns the revisions kept on the workload, the most recent first
+func History(namespace string, kind string, name string) ([]Revision, error) {
+	w, err := getWorkload(namespace, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	return readHistory(w.Meta)
+}
+
+// RequestRevert sets the revert annotation on the workload for the controller to restore the revision
+func RequestRevert(namespace string, kind string, name string, revision int) error {
+	w, err := getWorkload(namespace, kind, name)
+	if err != nil {
+		return err
+	}
+
+	history, err := readHistory(w.Meta)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", historyKey, err)
+	}
+	if revision < 1 || revision > len(history) {
+		return fmt.Errorf("revision %d not found, the history has %d", revision, len(history))
+	}
+
+	value := strconv.Itoa(revision)
+	return w.annotate(map[string]*string{revertKey: &value})
+}
+
+// RevertState tells if the revert annotation is still set on the workload and returns its pause reason
+func RevertState(namespace string, kind string, name string) (bool, string, error) {
+	w, err := getWorkload(namespace, kind, name)
+	if err != nil {
+		return false, "", err
+	}
+	_, pending := w.Meta.Annotations[revertKey]
+	reason, _ := isPaused(w)
+	return pending, reason, nil
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"Tupyrae/internal/k8s"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+var managedResources = []v1.ResourceName{v1.ResourceCPU, v1.ResourceMemory}
+
+// hpaResources returns the resources used as scaling metric by the HPAs targeting the workload
+func hpaResources(w *Workload) ([]v1.ResourceName, error) {
+	hpas, err := k8s.GetHpas(w.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var resources []v1.ResourceName
+	for _, hpa := range hpas {
+		if hpa.Spec.ScaleTargetRef.Kind != w.Kind || hpa.Spec.ScaleTargetRef.Name != w.Name {
+			continue
+		}
+
+		// An HPA without metrics scales on CPU utilization
+		if len(hpa.Spec.Metrics) == 0 {
+			resources = appendResource(resources, v1.ResourceCPU)
+		}
+		for _, m := range hpa.Spec.Metrics {
+			switch m.Type {
+			case autoscalingv2.ResourceMetricSourceType:
+				resources = appendResource(resources, m.Resource.Name)
+			case autoscalingv2.ContainerResourceMetricSourceType:
+				resources = appendResource(resources, m.ContainerResource.Name)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// controlledResources returns the resources of the policy Tupyrae may adjust on the workload
+// and false when the workload must be skipped because of an HPA, no resource being controlled then.
+// Both the generated VPA and the adjustments derive from it, so they always control the same resources.
+// Without the HPAs the conflicts are unknown, an error is returned rather than all the resources
+func controlledResources(w *Workload, policy Policy) ([]v1.ResourceName, bool, error) {
+	conflicts, err := hpaResources(w)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(conflicts) == 0 {
+		return policy.Resources, true, nil
+	}
+
+	switch policy.Hpa {
+	case HpaPolicyWarn:
+		klog.Warningf("%s %s/%s is scaled by an HPA on %v, adjusting anyway", w.Kind, w.Namespace, w.Name, conflicts)
+		return policy.Resources, true, nil
+	case HpaPolicySkip:
+		return []v1.ResourceName{}, false, nil
+	default:
+		return withoutResources(policy.Resources, conflicts), true, nil
+	}
+}
+
+func appendResource(resources []v1.ResourceName, name v1.ResourceName) []v1.ResourceName {
+	if hasResource(resources, name) {
+		return resources
+	}
+	return append(resources, name)
+}
+
+func withoutResources(resources []v1.ResourceName, excluded []v1.ResourceName) []v1.ResourceName {
+	result := []v1.ResourceName{}
+	for _, r := range resources {
+		if !hasResource(excluded, r) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func hasResource(resources []v1.ResourceName, name v1.ResourceName) bool {
+	for _, r := range resources {
+		if r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog"
+)
+
+// jobGroupChunk is the number of pods matched by a query of a Job group
+const jobGroupChunk = 50
+
+// jobGroup gathers the standalone Jobs and bare Pods of a namespace sharing a value of the group
+// label. Their templates are immutable once submitted, the recommendations are published for
+// the submitter to use on the next runs
+type jobGroup struct {
+	namespace string
+	name      string
+	pods      []v1.Pod
+}
+
+// JobRecommendation is the entry of a Job group in the recommendations ConfigMap
+type JobRecommendation struct {
+	// Runs is the number of completed runs learnt from
+	Runs       int                                `json:"runs"`
+	Source     string                             `json:"source"`
+	Containers map[string]v1.ResourceRequirements `json:"containers"`
+}
+
+// JobGroupRun periodically learns the resources of the Job groups from their finished pods and
+// writes them to the recommendations ConfigMap of the namespace
+func JobGroupRun() {
+	label := config.Get().JobGroupLabel
+	pods, err := k8s.GetPods("", label)
+	if err != nil {
+		klog.Errorf("Error getting the pods of the Job groups: %v", err)
+		return
+	}
+
+	jobs := map[string]map[string]batchv1.Job{}
+	groups := map[string]*jobGroup{}
+	for _, pod := range pods {
+		if !isStandalone(&pod, jobs) {
+			continue
+		}
+
+		key := keyCache(pod.Namespace, pod.Labels[label])
+		g, ok := groups[key]
+		if !ok {
+			g = &jobGroup{namespace: pod.Namespace, name: pod.Labels[label]}
+			groups[key] = g
+		}
+		g.pods = append(g.pods, pod)
+	}
+
+	namespaces := map[string]*v1.Namespace{}
+	for _, g := range groups {
+		w := g.workload()
+		if ns, ok := namespaces[w.Namespace]; ok {
+			w.ns = ns
+		} else {
+			namespaces[w.Namespace] = w.namespace()
+		}
+
+		if !isManaged(w) {
+			continue
+		}
+
+		// The group name is the key of its recommendation in the ConfigMap
+		if errs := validation.IsConfigMapKey(g.name); len(errs) > 0 {
+			pod := &Workload{Kind: "Pod", APIVersion: "v1", Name: w.Meta.Name, Namespace: w.Namespace, Meta: w.Meta}
+			recordEventOnce(pod, v1.EventTypeWarning, "InvalidJobGroup",
+				fmt.Sprintf("%s=%q is not a valid key of the ConfigMap %s, skipping the group: %s", label, g.name, config.Get().JobConfigMap, strings.Join(errs, ", ")))
+			continue
+		}
+
+		if err := recommendJobGroup(g, w); err != nil {
+			klog.Errorf("Error recommending resources for Job group %s/%s: %v", g.namespace, g.name, err)
+		}
+	}
+}
+
+// isStandalone tells if the pod belongs to a Job not created by a CronJob or to no controller at all
+func isStandalone(pod *v1.Pod, jobs map[string]map[string]batchv1.Job) bool {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return true
+	}
+	if ref.Kind != "Job" {
+		return false
+	}
+
+	if _, ok := jobs[pod.Namespace]; !ok {
+		list, err := k8s.GetJobs(pod.Namespace)
+		if err != nil {
+			klog.Errorf("Error getting Jobs of %s: %v", pod.Namespace, err)
+			return false
+		}
+		jobs[pod.Namespace] = map[string]batchv1.Job{}
+		for _, job := range list {
+			jobs[pod.Namespace][job.Name] = job
+		}
+	}
+
+	job, ok := jobs[pod.Namespace][ref.Name]
+	return ok && metav1.GetControllerOf(&job) == nil
+}
+
+// workload represents the group by its latest pod, for the policy and the current resources
+func (g *jobGroup) workload() *Workload {
+	sort.Slice(g.pods, func(i, j int) bool {
+		return g.pods[i].CreationTimestamp.Before(&g.pods[j].CreationTimestamp)
+	})
+	latest := &g.pods[len(g.pods)-1]
+
+	return &Workload{
+		Kind:       "Job",
+		APIVersion: "batch/v1",
+		Name:       g.name,
+		Namespace:  g.namespace,
+		Meta:       &latest.ObjectMeta,
+		PodSpec:    &latest.Spec,
+		Item:       latest,
+	}
+}
+
+func (g *jobGroup) history() *jobHistory {
+	history := &jobHistory{minRuns: config.Get().CronJobMinRuns}
+	for i := range g.pods {
+		pod := &g.pods[i]
+		switch pod.Status.Phase {
+		case v1.PodSucceeded:
+			history.succeeded++
+			if finished := finishedAt(pod); pod.Status.StartTime != nil && !finished.IsZero() {
+				history.durations = append(history.durations, finished.Sub(pod.Status.StartTime.Time))
+			}
+		case v1.PodFailed:
+			history.failed++
+		}
+		history.addOomKilled(pod)
+	}
+	return history
+}
+
+// selectors match the series of the pods of the group, the pod names share no pattern. The names are
+// split in chunks of jobGroupChunk so the queries stay within the URL limits of large groups
+func (g *jobGroup) selectors() []string {
+	var selectors []string
+	for start := 0; start < len(g.pods); start += jobGroupChunk {
+		names := make([]string, 0, jobGroupChunk)
+		for _, pod := range g.pods[start:min(start+jobGroupChunk, len(g.pods))] {
+			names = append(names, regexp.QuoteMeta(pod.Name))
+		}
+		selectors = append(selectors, fmt.Sprintf(`namespace=%q,pod=~%q,container!="",container!="POD"`, g.namespace, strings.Join(names, "|")))
+	}
+	return selectors
+}
+
+// finishedAt returns when the last container of the pod terminated
+func finishedAt(pod *v1.Pod) metav1.Time {
+	var finished metav1.Time
+	for _, s := range pod.Status.ContainerStatuses {
+		if t := s.State.Terminated; t != nil && finished.Before(&t.FinishedAt) {
+			finished = t.FinishedAt
+		}
+	}
+	return finished
+}
+
+// recommendJobGroup learns the resources of the group from Prometheus, the only source knowing
+// the usage of finished pods, and publishes them when they changed
+func recommendJobGroup(g *jobGroup, w *Workload) error {
+	history := g.history()
+	if message, ok := history.ready(); !ok {
+		klog.Infof("Job group %s/%s: %s", g.namespace, g.name, message)
+		return nil
+	}
+
+	selectors := g.selectors()
+	recommendations, err := prometheusRecommendation(getPrometheus(), selectors...)
+	if err != nil {
+		return err
+	}
+	peaks, err := peakMemory(getPrometheus(), selectors...)
+	if err != nil {
+		return err
+	}
+
+	containers := containerList(w.PodSpec)
+	recommendations = history.size(recommendations, peaks, containers)
+
+	policy := getPolicy(w)
+	rec := JobRecommendation{
+		Runs:       history.succeeded,
+		Source:     sources(recommendations),
+		Containers: map[string]v1.ResourceRequirements{},
+	}
+	for _, r := range recommendations {
+		if policy.isIgnoredContainer(r.ContainerName) || findContainer(containers, r.ContainerName) == nil {
+			continue
+		}
+
+		resources := v1.ResourceRequirements{}
+		if policy.adjustRequests() {
+			resources.Requests = filterResources(policy.requestsRecommendation(r), policy.Resources)
+		}
+		if policy.adjustLimits() {
+			resources.Limits = filterResources(r.UpperBound, policy.Resources)
+		}
+		rec.Containers[r.ContainerName] = resources
+	}
+
+	if len(rec.Containers) == 0 {
+		return nil
+	}
+	return publishJobRecommendation(g, rec)
+}
+
+// publishJobRecommendation writes the recommendation of the group to the namespace ConfigMap,
+// under the group name
+func publishJobRecommendation(g *jobGroup, rec JobRecommendation) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	name := config.Get().JobConfigMap
+	cm, err := k8s.GetConfigMap(g.namespace, name)
+	if errors.IsNotFound(err) {
+		_, err = k8s.CreateConfigMap(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: g.namespace,
+				Labels:    map[string]string{ownerLabel: owner},
+			},
+			Data: map[string]string{g.name: string(data)},
+		})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if current, ok := cm.Data[g.name]; ok && sameJobRecommendation(current, rec) {
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[g.name] = string(data)
+	klog.Infof("Recommending for Job group %s/%s from %s: %s", g.namespace, g.name, rec.Source, string(data))
+	_, err = k8s.UpdateConfigMap(cm)
+	return err
+}
+
+// sameJobRecommendation compares the resources only, new runs alone do not rewrite the entry
+func sameJobRecommendation(current string, rec JobRecommendation) bool {
+	var previous JobRecommendation
+	if err := json.Unmarshal([]byte(current), &previous); err != nil {
+		return false
+	}
+	return equality.Semantic.DeepEqual(previous.Containers, rec.Containers)
+}
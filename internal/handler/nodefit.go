@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/klog"
+)
+
+var nodeSelectorOperators = map[v1.NodeSelectorOperator]selection.Operator{
+	v1.NodeSelectorOpIn:           selection.In,
+	v1.NodeSelectorOpNotIn:        selection.NotIn,
+	v1.NodeSelectorOpExists:       selection.Exists,
+	v1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	v1.NodeSelectorOpGt:           selection.GreaterThan,
+	v1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// fitNodes caps the pod requests so the pod still fits on at least one of the nodes it
+// can be scheduled on, keeping a margin of the node allocatable free
+func fitNodes(w *Workload) {
+	nodes, err := k8s.GetNodes()
+	if err != nil {
+		klog.Errorf("Error getting Nodes: %v", err)
+		return
+	}
+
+	var eligible []v1.Node
+	for _, node := range nodes {
+		if isEligible(w.PodSpec, &node) {
+			eligible = append(eligible, node)
+		}
+	}
+
+	if len(eligible) == 0 {
+		klog.Infof("No eligible node found for %s/%s, skipping node fit check", w.Namespace, w.Name)
+		return
+	}
+
+	requests, _ := podResources(w.PodSpec)
+	margin := config.Get().NodeMargin
+
+	// Pick the node on which the requests need the smallest cut
+	var best map[v1.ResourceName]float64
+	bestScore := -1.0
+	for _, node := range eligible {
+		factors, score := fitFactors(requests, node.Status.Allocatable, margin)
+		if score > bestScore {
+			best, bestScore = factors, score
+		}
+	}
+
+	if bestScore == float64(len(managedResources)) {
+		return
+	}
+
+	for name, factor := range best {
+		if factor >= 1 {
+			continue
+		}
+		for _, c := range allContainers(w.PodSpec) {
+			if q, ok := c.Resources.Requests[name]; ok {
+				c.Resources.Requests[name] = scaleQuantity(q, factor)
+			}
+		}
+		total := requests[name]
+		recordEventOnce(w, v1.EventTypeNormal, "NodeFitCapped", fmt.Sprintf("%s requests capped to %.0f%% of %s so the pod fits on an eligible node", name, factor*100, total.String()))
+	}
+}
+
+// fitFactors returns, per resource, the factor to apply to the requests to fit in the
+// allocatable minus the margin and the sum of the factors as a score
+func fitFactors(requests v1.ResourceList, allocatable v1.ResourceList, margin float64) (map[v1.ResourceName]float64, float64) {
+	factors := map[v1.ResourceName]float64{}
+	score := 0.0
+	for _, name := range managedResources {
+		factor := 1.0
+		request, available := requests[name], allocatable[name]
+		capacity := float64(available.MilliValue()) * (1 - margin)
+		if request.MilliValue() > 0 && float64(request.MilliValue()) > capacity {
+			factor = capacity / float64(request.MilliValue())
+		}
+		factors[name] = factor
+		score += factor
+	}
+	return factors, score
+}
+
+func isEligible(spec *v1.PodSpec, node *v1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		matched := false
+		for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			if matchesTerm(node, term) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !toleratesTaint(spec.Tolerations, &taint) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesTerm(node *v1.Node, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, expr := range term.MatchExpressions {
+		if !matchesRequirement(labels.Set(node.Labels), expr) {
+			return false
+		}
+	}
+
+	for _, expr := range term.MatchFields {
+		if !matchesRequirement(labels.Set{"metadata.name": node.Name}, expr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesRequirement(set labels.Set, expr v1.NodeSelectorRequirement) bool {
+	req, err := labels.NewRequirement(expr.Key, nodeSelectorOperators[expr.Operator], expr.Values)
+	if err != nil {
+		return false
+	}
+	return req.Matches(set)
+}
+
+func toleratesTaint(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for _, t := range tolerations {
+		if t.ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
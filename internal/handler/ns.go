@@ -1,48 +1,138 @@
 package handler
 
 import (
+	"Tupyrae/internal/config"
 	"Tupyrae/internal/k8s"
 	"fmt"
 
+	"github.com/patrickmn/go-cache"
 	appsv1 "k8s.io/api/apps/v1"
 	autoscaling "k8s.io/api/autoscaling/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	vpav1 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
 	"k8s.io/klog"
 )
 
 const (
-	key = "tupyrae"
-	val = "true"
+	ownerLabel = "owener"
+	owner      = "tupyrae"
+	// adoptKey is the annotation handing a VPA authored by a user over to Tupyrae
+	adoptKey = "tupyrae/adopt"
 )
 
+const (
+	// ModeOff leaves the namespace alone
+	ModeOff = "off"
+	// ModeRecommend creates the VPAs and reports the adjustments without applying them
+	ModeRecommend = "recommend"
+	// ModeApply creates the VPAs and applies the adjustments
+	ModeApply = "apply"
+	// ModeAutoVpa creates the VPAs in Auto mode and lets the VPA updater resize the pods
+	ModeAutoVpa = "auto-vpa"
+)
+
+var nsSelector labels.Selector
+
+// namespaces holds the namespaces seen by the informer, their labels deciding the policies without
+// API calls
+var namespaces = cache.New(cache.NoExpiration, 0)
+
 func NsRun(r Resource) error {
 	if _, ok := r.Item.(*corev1.Namespace); !ok {
 		return fmt.Errorf("Item is not a Namespace")
 	}
 
 	ns := r.Item.(*corev1.Namespace)
+	if r.Action == "Delete" {
+		namespaces.Delete(ns.Name)
+		return nil
+	}
+	namespaces.Set(ns.Name, ns.DeepCopy(), cache.NoExpiration)
+	refreshApplied(ns.Name)
 	checkNamespace(ns)
 
 	return nil
 }
 
+// ParseNamespaceSelector parses the configured namespace selector, once at start before any informer runs
+func ParseNamespaceSelector() error {
+	selector, err := labels.Parse(config.Get().NamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid namespace selector %q: %v", config.Get().NamespaceSelector, err)
+	}
+	nsSelector = selector
+	return nil
+}
+
+// NamespaceSelector returns the label selector of the namespaces Tupyrae manages, parsed at start
+func NamespaceSelector() labels.Selector {
+	return nsSelector
+}
+
+// namespaceMode returns the mode of the namespace, off when it is not selected
+func namespaceMode(namespace *corev1.Namespace) string {
+	if !NamespaceSelector().Matches(labels.Set(namespace.Labels)) {
+		return ModeOff
+	}
+
+	value, ok := namespace.Labels[config.Get().NamespaceLabel]
+	if !ok {
+		return config.Get().DefaultMode
+	}
+
+	mode, err := parseMode(value)
+	if err != nil {
+		klog.Errorf("Namespace %s: %v", namespace.Name, err)
+	}
+	return mode
+}
+
+func parseMode(value string) (string, error) {
+	switch value {
+	case "true":
+		return ModeApply, nil
+	case "false":
+		return ModeOff, nil
+	case ModeOff, ModeRecommend, ModeApply, ModeAutoVpa:
+		return value, nil
+	}
+	return ModeOff, fmt.Errorf("unknown mode %q", value)
+}
+
+func isNamespaceEnabled(namespace *corev1.Namespace) bool {
+	return namespaceMode(namespace) != ModeOff
+}
+
 func checkNamespace(namespace *corev1.Namespace) {
-	if namespace.Labels[key] == val {
+	// Without the VPA the workloads are evaluated by RecommenderRun
+	if !UsesVpa() {
+		return
+	}
+
+	if isNamespaceEnabled(namespace) {
 		vpas := mapperVpa(namespace)
+		if vpas == nil {
+			return
+		}
+
+		// The workloads opted out keep no VPA, like in checkWorkload
 		for _, deploy := range k8s.GetDeploys(namespace.Name) {
-			key := getKey(deploy)
-			if _, ok := vpas[key]; !ok {
-				createVpaByDeployment(deploy)
+			w := workloadByDeployment(&deploy)
+			w.ns = namespace
+			if isManaged(w) {
+				syncVpa(w, vpas)
 			}
 		}
 
 		for _, cron := range k8s.GetCronJobs(namespace.Name) {
-			key := getKey(cron)
-			if _, ok := vpas[key]; !ok {
-				createVpaByCronJob(cron)
+			w := workloadByCronJob(&cron)
+			w.ns = namespace
+			if isManaged(w) {
+				syncVpa(w, vpas)
 			}
 		}
 	}
@@ -57,54 +147,132 @@ func mapperVpa(ns *corev1.Namespace) map[string]vpav1.VerticalPodAutoscaler {
 	mapVpa := make((map[string]vpav1.VerticalPodAutoscaler), 0)
 	for _, vpa := range vpas {
 		mapKey := getKey(vpa)
-		if _, ok := mapVpa[mapKey]; !ok {
+		// The VPA generated by Tupyrae wins over the ones authored by users
+		if current, ok := mapVpa[mapKey]; !ok || (!isOwned(&current) && isOwned(&vpa)) {
 			mapVpa[mapKey] = vpa
 		}
 	}
 	return mapVpa
 }
 
-func createVpa(name string, namespace string, kind string, apiVersion string, labels map[string]string) {
-	var mode vpav1.UpdateMode = vpav1.UpdateModeOff
+// syncVpa creates the VPA of the workload, or updates the existing one when Tupyrae owns it
+// and its spec drifted from the configuration, VPAs authored by users are left alone
+func syncVpa(w *Workload, vpas map[string]vpav1.VerticalPodAutoscaler) {
+	desired, err := desiredVpa(w)
+	if err != nil {
+		klog.Errorf("Error building the VPA of %s/%s, leaving it as is: %v", w.Namespace, w.Name, err)
+		return
+	}
+
+	live, ok := vpas[getKey(w)]
+	if !ok {
+		if _, err := k8s.CreateVpa(desired); err != nil {
+			klog.Errorf("Error creating VPA for %s: %v", w.Name, err)
+		}
+		return
+	}
+
+	// Co-exist with VPAs authored by users, they are only acted on when adopted
+	if !isOwned(&live) {
+		if !isAdopted(&live) {
+			klog.Infof("VPA %s/%s is not owned by Tupyrae, leaving %s %s alone", live.Namespace, live.Name, w.Kind, w.Name)
+		}
+		return
+	}
+
+	drifted := !equality.Semantic.DeepEqual(live.Spec, desired.Spec)
+	for k, v := range desired.Labels {
+		if live.Labels[k] != v {
+			drifted = true
+		}
+	}
+	if !drifted {
+		return
+	}
+
+	updated := live.DeepCopy()
+	updated.Spec = desired.Spec
+	for k, v := range desired.Labels {
+		updated.Labels[k] = v
+	}
+	if _, err := k8s.UpdateVpa(updated); err != nil {
+		klog.Errorf("Error updating VPA %s/%s: %v", updated.Namespace, updated.Name, err)
+	}
+}
+
+// isOwned tells if the VPA was generated by Tupyrae
+func isOwned(vpa *vpav1.VerticalPodAutoscaler) bool {
+	return vpa.Labels[ownerLabel] == owner
+}
+
+// isAdopted tells if a VPA authored by a user was handed over to Tupyrae
+func isAdopted(vpa *vpav1.VerticalPodAutoscaler) bool {
+	return vpa.Annotations[adoptKey] == "true"
+}
+
+// desiredVpa builds the VPA Tupyrae wants for the workload
+func desiredVpa(w *Workload) (*vpav1.VerticalPodAutoscaler, error) {
 	vpa := &vpav1.VerticalPodAutoscaler{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-			Labels: map[string]string{
-				"owener": key,
-			},
+			Name:      w.Name,
+			Namespace: w.Namespace,
+			Labels:    map[string]string{},
 		},
 	}
 
-	for _, l := range labels {
-		vpa.ObjectMeta.Labels[l] = val
+	for k, v := range w.Meta.Labels {
+		vpa.ObjectMeta.Labels[k] = v
+	}
+
+	vpa.ObjectMeta.Labels[ownerLabel] = owner
+
+	policy := getPolicy(w)
+	var mode vpav1.UpdateMode = vpav1.UpdateModeOff
+	if policy.Mode == ModeAutoVpa {
+		mode = vpav1.UpdateModeAuto
 	}
 
-	vpa.ObjectMeta.Labels["owener"] = key
+	// The VPA only recommends the resources and containers Tupyrae is allowed to adjust, none when
+	// the workload is skipped because of an HPA
+	resources, _, err := controlledResources(w, policy)
+	if err != nil {
+		return nil, err
+	}
+	values := policy.vpaControlledValues()
+	containerPolicies := []vpav1.ContainerResourcePolicy{
+		{
+			ContainerName:       vpav1.DefaultContainerResourcePolicy,
+			ControlledResources: &resources,
+			ControlledValues:    &values,
+		},
+	}
+	for _, name := range policy.IgnoredContainers {
+		off := vpav1.ContainerScalingModeOff
+		containerPolicies = append(containerPolicies, vpav1.ContainerResourcePolicy{
+			ContainerName: name,
+			Mode:          &off,
+		})
+	}
 
 	vpa.Spec = vpav1.VerticalPodAutoscalerSpec{
 		TargetRef: &autoscaling.CrossVersionObjectReference{
-			APIVersion: apiVersion,
-			Kind:       kind,
-			Name:       name,
+			APIVersion: w.APIVersion,
+			Kind:       w.Kind,
+			Name:       w.Name,
 		},
 		UpdatePolicy: &vpav1.PodUpdatePolicy{
 			UpdateMode: &mode,
 		},
+		ResourcePolicy: &vpav1.PodResourcePolicy{
+			ContainerPolicies: containerPolicies,
+		},
 	}
 
-	_, err := k8s.CreateVpa(vpa)
-	if err != nil {
-		klog.Errorf("Error creating VPA for %s: %v", name, err)
+	for _, name := range policy.Recommenders {
+		vpa.Spec.Recommenders = append(vpa.Spec.Recommenders, &vpav1.VerticalPodAutoscalerRecommenderSelector{Name: name})
 	}
-}
-
-func createVpaByDeployment(deploy appsv1.Deployment) {
-	createVpa(deploy.Name, deploy.Namespace, "Deployment", "apps/v1", deploy.Labels)
-}
 
-func createVpaByCronJob(cron batchv1.CronJob) {
-	createVpa(cron.Name, cron.Namespace, "CronJob", "batch/v1", cron.Labels)
+	return vpa, nil
 }
 
 func getKey(obj interface{}) string {
@@ -113,6 +281,9 @@ func getKey(obj interface{}) string {
 		return fmt.Sprintf("%s_%s", "Deployment", obj.(appsv1.Deployment).Name)
 	case batchv1.CronJob:
 		return fmt.Sprintf("%s_%s", "CronJob", obj.(batchv1.CronJob).Name)
+	case *Workload:
+		w := obj.(*Workload)
+		return fmt.Sprintf("%s_%s", w.Kind, w.Name)
 	case vpav1.VerticalPodAutoscaler:
 		vpa := obj.(vpav1.VerticalPodAutoscaler)
 		return fmt.Sprintf("%s_%s", vpa.Spec.TargetRef.Kind, vpa.Spec.TargetRef.Name)
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"fmt"
+
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// OutputUpdate updates the workload templates, rolling the pods out
+	OutputUpdate = "update"
+	// OutputWebhook injects the resources in the new pods at admission, the templates are untouched
+	OutputWebhook = "webhook"
+	// OutputResize resizes the running pods in place, the templates are untouched
+	OutputResize = "resize"
+	// OutputGitops commits patches to a git working tree for the GitOps tool to sync
+	OutputGitops = "gitops"
+)
+
+// resourcesKey holds the resources the pods get instead of the template ones, by the webhook and
+// resize outputs, gzipped JSON in base64. It outlives the controller, read back after a restart
+const resourcesKey = "tupyrae/resources"
+
+// applied is a workload whose pods get other resources than its template ones
+type applied struct {
+	// w holds the metadata of the workload, for its policy
+	w         *Workload
+	resources map[string]v1.ResourceRequirements
+	// active tells the workload is managed in apply mode, its pods get the resources
+	active bool
+}
+
+// appliedCache holds the workloads with a resources annotation, filled from the informer events so
+// the pods are handled without API calls. The policy is decided there too, from the informer state
+var appliedCache = cache.New(cache.NoExpiration, 0)
+
+func appliedKey(namespace string, kind string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, kind, name)
+}
+
+// rememberApplied keeps the resources annotation of the workload for its next pods
+func rememberApplied(w *Workload) {
+	if output := config.Get().Output; output != OutputWebhook && output != OutputResize {
+		return
+	}
+
+	key := appliedKey(w.Namespace, w.Kind, w.Name)
+	resources := appliedResources(w)
+	if resources == nil {
+		appliedCache.Delete(key)
+		return
+	}
+
+	// The workload may be adjusted meanwhile, the pods are handled with a copy of its metadata
+	meta := &Workload{Kind: w.Kind, APIVersion: w.APIVersion, Name: w.Name, Namespace: w.Namespace, Meta: w.Meta.DeepCopy()}
+	appliedCache.Set(key, newApplied(meta, resources), cache.NoExpiration)
+}
+
+func newApplied(w *Workload, resources map[string]v1.ResourceRequirements) *applied {
+	return &applied{w: w, resources: resources, active: isManaged(w) && getPolicy(w).Mode == ModeApply}
+}
+
+// refreshApplied decides again the workloads of the namespace, its labels and annotations changed
+func refreshApplied(namespace string) {
+	for key, item := range appliedCache.Items() {
+		a := item.Object.(*applied)
+		if a.w.Namespace != namespace {
+			continue
+		}
+		appliedCache.Set(key, newApplied(a.w, a.resources), cache.NoExpiration)
+	}
+}
+
+func forgetApplied(namespace string, kind string, name string) {
+	appliedCache.Delete(appliedKey(namespace, kind, name))
+}
+
+// podApplied returns the workload of the pod and the resources its pods get, nil when the pod
+// keeps the template ones
+func podApplied(pod *v1.Pod) (*Workload, map[string]v1.ResourceRequirements) {
+	kind, name := podOwner(pod)
+	if kind == "" {
+		return nil, nil
+	}
+
+	found, ok := appliedCache.Get(appliedKey(pod.Namespace, kind, name))
+	if !ok {
+		return nil, nil
+	}
+	a := found.(*applied)
+	if !a.active {
+		return nil, nil
+	}
+	return a.w, a.resources
+}
+
+// commit sends the adjusted resources of the workload to the configured output. It returns false
+// when the output already had them, nothing changed then
+func commit(w *Workload) (bool, error) {
+	switch config.Get().Output {
+	case OutputWebhook:
+		if err := annotateResources(w); err != nil {
+			return false, err
+		}
+		rememberApplied(w)
+		klog.Infof("Resources of %s %s/%s will be set at pod admission", w.Kind, w.Namespace, w.Name)
+		return true, nil
+	case OutputResize:
+		return true, resizePods(w)
+	case OutputGitops:
+		return commitPatch(w)
+	case OutputUpdate:
+		return true, w.Update()
+	}
+	return false, fmt.Errorf("unknown output %q", config.Get().Output)
+}
+
+// overlay replaces the template resources with the ones the pods get at admission or by a resize,
+// or the ones committed to the GitOps repository and not synced yet. The adjustments then start
+// from what actually runs or soon will
+func overlay(w *Workload) {
+	var resources map[string]v1.ResourceRequirements
+	switch config.Get().Output {
+	case OutputWebhook, OutputResize:
+		resources = appliedResources(w)
+	case OutputGitops:
+		resources = committedResources(w)
+	}
+	if resources != nil {
+		injectResources(w.PodSpec, resources)
+	}
+}
+
+// appliedResources reads the resources annotation of the workload, nil when unset
+func appliedResources(w *Workload) map[string]v1.ResourceRequirements {
+	value, ok := w.Meta.Annotations[resourcesKey]
+	if !ok {
+		return nil
+	}
+
+	var resources map[string]v1.ResourceRequirements
+	if err := decodeAnnotation(value, &resources); err != nil {
+		klog.Errorf("Invalid %s of %s %s/%s: %v", resourcesKey, w.Kind, w.Namespace, w.Name, err)
+		return nil
+	}
+	return resources
+}
+
+// annotateResources saves the adjusted resources of the workload in the resources annotation
+func annotateResources(w *Workload) error {
+	value, err := encodeAnnotation(specResources(w.PodSpec))
+	if err != nil {
+		return err
+	}
+	return w.annotate(map[string]*string{resourcesKey: &value})
+}
+
+// specResources returns the resources of every container of the pod, by name
+func specResources(spec *v1.PodSpec) map[string]v1.ResourceRequirements {
+	resources := map[string]v1.ResourceRequirements{}
+	for _, c := range allContainers(spec) {
+		resources[c.Name] = *c.Resources.DeepCopy()
+	}
+	return resources
+}
+
+// injectResources sets the resources of the containers of the pod found in the map
+func injectResources(spec *v1.PodSpec, resources map[string]v1.ResourceRequirements) {
+	for _, c := range allContainers(spec) {
+		if r, ok := resources[c.Name]; ok {
+			c.Resources = *r.DeepCopy()
+		}
+	}
+}
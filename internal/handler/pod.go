@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const oomKilled = "OOMKilled"
+
+// oomCache remembers the OOM kills already handled, a pod keeps reporting its last termination
+var oomCache = cache.New(24*time.Hour, time.Hour)
+
+func PodRun(r Resource) error {
+	if _, ok := r.Item.(*v1.Pod); !ok {
+		return fmt.Errorf("Item is not a Pod")
+	}
+
+	if r.Action == "Delete" {
+		return nil
+	}
+
+	pod := r.Item.(*v1.Pod)
+	checkPod(pod)
+
+	return nil
+}
+
+func checkPod(pod *v1.Pod) {
+	if config.Get().Output == OutputResize {
+		resizeNewPod(pod)
+		reportResize(pod)
+	}
+
+	kills := oomKills(pod)
+	if len(kills) == 0 {
+		return
+	}
+
+	// The kills are handled again at the next event of the pod until the raise is committed
+	w, err := getPodOwner(pod)
+	if err != nil {
+		klog.Errorf("Error resolving owner of Pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return
+	}
+
+	if w == nil || !isManaged(w) || oomAdjust(w, pod, oomContainers(kills)) {
+		for _, kill := range kills {
+			oomCache.Set(kill.key, true, cache.DefaultExpiration)
+		}
+	}
+}
+
+// oomKill is the last OOM kill of a container, the key telling it apart from the previous ones
+type oomKill struct {
+	container string
+	key       string
+}
+
+// oomKills returns the OOM kills of the pod not handled yet, of init containers and sidecars too
+func oomKills(pod *v1.Pod) []oomKill {
+	var kills []oomKill
+	statuses := append(append([]v1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, s := range statuses {
+		terminated := s.State.Terminated
+		if terminated == nil {
+			terminated = s.LastTerminationState.Terminated
+		}
+		if terminated == nil || terminated.Reason != oomKilled {
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%s/%d/%s", pod.UID, s.Name, s.RestartCount, terminated.FinishedAt.String())
+		if _, found := oomCache.Get(key); found {
+			continue
+		}
+		kills = append(kills, oomKill{container: s.Name, key: key})
+	}
+	return kills
+}
+
+func oomContainers(kills []oomKill) []string {
+	names := make([]string, 0, len(kills))
+	for _, kill := range kills {
+		names = append(names, kill.container)
+	}
+	return names
+}
+
+func getPodOwner(pod *v1.Pod) (*Workload, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+
+	switch ref.Kind {
+	case "ReplicaSet":
+		rs, err := k8s.GetReplicaSet(pod.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		if owner := metav1.GetControllerOf(rs); owner != nil && owner.Kind == "Deployment" {
+			return getWorkload(pod.Namespace, owner.Kind, owner.Name)
+		}
+	case "Job":
+		job, err := k8s.GetJob(pod.Namespace, ref.Name)
+		if err != nil {
+			return nil, err
+		}
+		if owner := metav1.GetControllerOf(job); owner != nil && owner.Kind == "CronJob" {
+			return getWorkload(pod.Namespace, owner.Kind, owner.Name)
+		}
+	}
+
+	return nil, nil
+}
+
+// oomAdjust raises the memory of the killed containers right away, without waiting
+// for the VPA recommendation, the cooldown or the outOfLimit band. The raise still has to fit the
+// nodes, the QoS class, the LimitRanges and the ResourceQuotas. It returns false when the raise
+// could not be committed, to try again
+func oomAdjust(w *Workload, pod *v1.Pod, containers []string) bool {
+	factor := config.Get().OomMemoryFactor
+	policy := getPolicy(w)
+	if policy.Mode != ModeApply || !hasResource(policy.Resources, v1.ResourceMemory) {
+		return true
+	}
+	if _, paused := isPaused(w); paused {
+		klog.Infof("Not raising memory of paused %s %s/%s", w.Kind, w.Namespace, w.Name)
+		return true
+	}
+
+	overlay(w)
+	original := w.PodSpec.DeepCopy()
+	var updated bool = false
+	for _, name := range containers {
+		if policy.isIgnoredContainer(name) {
+			continue
+		}
+
+		c, _ := podContainer(w.PodSpec, name, policy)
+		running := findContainer(containerList(&pod.Spec), name)
+		if c == nil || running == nil {
+			continue
+		}
+
+		// Another replica already triggered the raise since this pod was created
+		if current := memoryOf(c.Resources); current.Cmp(memoryOf(running.Resources)) > 0 {
+			continue
+		}
+
+		// Only the values the policy controls are raised
+		requests := policy.adjustRequests() && raiseMemory(c.Resources.Requests, factor)
+		limits := policy.adjustLimits() && raiseMemory(c.Resources.Limits, factor)
+		if !requests && !limits {
+			klog.Infof("Container %s of %s/%s was OOMKilled but has no memory to raise", name, w.Namespace, w.Name)
+			continue
+		}
+
+		klog.Infof("Raising memory of %s %s/%s container %s after OOMKill: %v %v", w.Kind, w.Namespace, w.Name, name, c.Resources.Requests.Memory(), c.Resources.Limits.Memory())
+		updated = true
+	}
+
+	if !updated {
+		return true
+	}
+
+	fitNodes(w)
+	if !preserveQos(w, policy, original) || !validateResources(w, original) {
+		klog.Infof("Not raising memory of %s %s/%s, it would change the QoS class or break a LimitRange or ResourceQuota", w.Kind, w.Namespace, w.Name)
+		return true
+	}
+	// The LimitRanges may have clamped the raise back to the current resources
+	if equality.Semantic.DeepEqual(original, w.PodSpec) {
+		return true
+	}
+
+	if policy.Approval {
+		reason := fmt.Sprintf("Containers %s of Pod %s were OOMKilled", strings.Join(containers, ","), pod.Name)
+		if err := proposeChange(w, original, reason); err != nil {
+			klog.Errorf("Error proposing the adjustment of %s/%s: %v", w.Namespace, w.Name, err)
+			return false
+		}
+		return true
+	}
+
+	reason := fmt.Sprintf("OOMKilled in Pod %s", pod.Name)
+	changed, err := commitRevision(w, original)
+	if err != nil {
+		klog.Errorf("Error updating %s %s/%s: %v", w.Kind, w.Namespace, w.Name, err)
+		auditAdjustment(w, original, nil, policy, ResultFailed, reason+": "+err.Error())
+		return false
+	}
+	setCache(w.Namespace, w.Name)
+	if changed {
+		auditAdjustment(w, original, nil, policy, ResultSucceeded, reason)
+	}
+	return true
+}
+
+func memoryOf(r v1.ResourceRequirements) resource.Quantity {
+	if mem, ok := r.Requests[v1.ResourceMemory]; ok {
+		return mem
+	}
+	return *r.Limits.Memory()
+}
+
+func raiseMemory(list v1.ResourceList, factor float64) bool {
+	mem, ok := list[v1.ResourceMemory]
+	if !ok || mem.IsZero() {
+		return false
+	}
+
+	list[v1.ResourceMemory] = *resource.NewQuantity(int64(float64(mem.Value())*factor), resource.BinarySI)
+	return true
+}
@@ -0,0 +1,254 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	vpav1 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+)
+
+const (
+	// HpaPolicySkip leaves workloads scaled by an HPA on CPU or memory untouched
+	HpaPolicySkip = "skip"
+	// HpaPolicyExclude only manages the resources the HPA does not scale on
+	HpaPolicyExclude = "exclude"
+	// HpaPolicyWarn manages every resource and logs the conflict
+	HpaPolicyWarn = "warn"
+)
+
+const (
+	// InitContainersNone only adjusts the regular containers
+	InitContainersNone = "none"
+	// InitContainersSidecars also adjusts the native sidecars, the restartable init containers
+	InitContainersSidecars = "sidecars"
+	// InitContainersAll also adjusts the sidecars and the classic init containers
+	InitContainersAll = "all"
+)
+
+// The values of tupyrae/controlled-values, the VPA names RequestsOnly and RequestsAndLimits are accepted
+// as well. The VPA has no limits only mode, its recommendations cover requests and limits for limits
+const (
+	// ControlledValuesRequests only adjusts the requests, RequestsOnly for the VPA
+	ControlledValuesRequests = "requests"
+	// ControlledValuesLimits only adjusts the limits, RequestsAndLimits for the VPA
+	ControlledValuesLimits = "limits"
+	// ControlledValuesBoth adjusts requests and limits, RequestsAndLimits for the VPA
+	ControlledValuesBoth = "both"
+)
+
+const (
+	// CronJobStrategyHistory sizes CronJobs from their completed Jobs, peak memory first
+	CronJobStrategyHistory = "history"
+	// CronJobStrategyVpa applies the recommendation to CronJobs like to Deployments
+	CronJobStrategyVpa = "vpa"
+)
+
+const (
+	RecommendationLowerBound = "lowerBound"
+	RecommendationTarget     = "target"
+	RecommendationUpperBound = "upperBound"
+)
+
+// Policy is the effective configuration for a workload, the controller defaults
+// overridden by the namespace annotations, themselves overridden by the workload ones
+type Policy struct {
+	// Mode comes from the namespace label or the workload opt-in
+	Mode           string
+	Hpa            string
+	Qos            string
+	InitContainers string
+	// IgnoredContainers are left untouched
+	IgnoredContainers []string
+	// Resources are the resources Tupyrae may adjust
+	Resources []v1.ResourceName
+	// Values tells if requests, limits or both are adjusted
+	Values string
+	// Threshold is the relative difference between limits and recommendation that triggers an adjustment
+	Threshold float64
+	// Recommendation is the recommendation field used for requests
+	Recommendation string
+	// MaintenanceWindow restricts when adjustments are applied
+	MaintenanceWindow string
+	// Recommenders are the VPA recommenders computing the recommendations, empty for the default one
+	Recommenders []string
+	// CronJobStrategy tells how CronJobs are sized
+	CronJobStrategy string
+	// GitopsPath is the directory of the workload patch in the GitOps working tree
+	GitopsPath string
+	// Approval holds the adjustments in TupyraeChanges until approved
+	Approval bool
+}
+
+func getPolicy(w *Workload) Policy {
+	policy := Policy{
+		Mode:              ModeOff,
+		Hpa:               config.Get().HpaPolicy,
+		Qos:               config.Get().QosPolicy,
+		InitContainers:    config.Get().InitContainersPolicy,
+		Resources:         managedResources,
+		Values:            ControlledValuesBoth,
+		Threshold:         config.Get().Threshold,
+		Recommendation:    config.Get().Recommendation,
+		MaintenanceWindow: config.Get().MaintenanceWindow,
+		Recommenders:      splitList(config.Get().Recommenders[w.Kind]),
+		CronJobStrategy:   config.Get().CronJobStrategy,
+		GitopsPath:        config.Get().GitopsPathTemplate,
+		Approval:          config.Get().Approval,
+	}
+
+	var invalid []string
+	if ns := w.namespace(); ns != nil {
+		policy.Mode = namespaceMode(ns)
+		invalid = policy.apply(ns.Annotations)
+	}
+	if mode, ok := workloadMode(w); ok {
+		policy.Mode = mode
+	}
+	invalid = append(invalid, policy.apply(w.Meta.Annotations)...)
+
+	for _, message := range invalid {
+		recordEventOnce(w, v1.EventTypeWarning, "InvalidAnnotation", message)
+	}
+
+	return policy
+}
+
+// apply overrides the policy with the annotations and returns why the invalid ones were ignored
+func (p *Policy) apply(annotations map[string]string) []string {
+	var invalid []string
+	enum := func(key string, field *string, allowed ...string) {
+		v, ok := annotations[key]
+		if !ok {
+			return
+		}
+		if !hasString(allowed, v) {
+			invalid = append(invalid, fmt.Sprintf("ignoring %s %q, expected %s", key, v, strings.Join(allowed, ", ")))
+			return
+		}
+		*field = v
+	}
+
+	enum("tupyrae/hpa-policy", &p.Hpa, HpaPolicySkip, HpaPolicyExclude, HpaPolicyWarn)
+	enum("tupyrae/qos-policy", &p.Qos, QosPolicyPreserve, QosPolicyAllow)
+	enum("tupyrae/init-containers", &p.InitContainers, InitContainersNone, InitContainersSidecars, InitContainersAll)
+	if v, ok := annotations["tupyrae/ignore-containers"]; ok {
+		p.IgnoredContainers = splitList(v)
+	}
+	if v, ok := annotations["tupyrae/controlled-resources"]; ok {
+		resources, err := parseResources(v)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+		} else {
+			p.Resources = resources
+		}
+	}
+	if v, ok := annotations["tupyrae/controlled-values"]; ok {
+		values, err := parseControlledValues(v)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+		} else {
+			p.Values = values
+		}
+	}
+	if v, ok := annotations["tupyrae/threshold"]; ok {
+		threshold, err := strconv.ParseFloat(v, 64)
+		switch {
+		case err != nil:
+			invalid = append(invalid, fmt.Sprintf("ignoring tupyrae/threshold %q: %v", v, err))
+		case !(threshold >= 0) || math.IsInf(threshold, 0):
+			invalid = append(invalid, fmt.Sprintf("ignoring tupyrae/threshold %q, expected a positive number", v))
+		default:
+			p.Threshold = threshold
+		}
+	}
+	enum("tupyrae/recommendation", &p.Recommendation, RecommendationLowerBound, RecommendationTarget, RecommendationUpperBound)
+	if v, ok := annotations["tupyrae/maintenance-window"]; ok {
+		p.MaintenanceWindow = v
+	}
+	if v, ok := annotations["tupyrae/recommenders"]; ok {
+		p.Recommenders = splitList(v)
+	}
+	enum("tupyrae/cronjob-strategy", &p.CronJobStrategy, CronJobStrategyHistory, CronJobStrategyVpa)
+	if v, ok := annotations["tupyrae/gitops-path"]; ok {
+		p.GitopsPath = v
+	}
+	if v, ok := annotations["tupyrae/approval"]; ok {
+		p.Approval = v == "true"
+	}
+	return invalid
+}
+
+// parseControlledValues reads tupyrae/controlled-values, in the Tupyrae or the VPA names
+func parseControlledValues(value string) (string, error) {
+	switch value {
+	case ControlledValuesRequests, string(vpav1.ContainerControlledValuesRequestsOnly):
+		return ControlledValuesRequests, nil
+	case ControlledValuesLimits:
+		return ControlledValuesLimits, nil
+	case ControlledValuesBoth, string(vpav1.ContainerControlledValuesRequestsAndLimits):
+		return ControlledValuesBoth, nil
+	default:
+		return "", fmt.Errorf("ignoring tupyrae/controlled-values %q, expected requests, limits or both", value)
+	}
+}
+
+// requestsRecommendation returns the recommendation field used for requests
+func (p Policy) requestsRecommendation(r Recommendation) v1.ResourceList {
+	switch p.Recommendation {
+	case RecommendationTarget:
+		return r.Target
+	case RecommendationUpperBound:
+		return r.UpperBound
+	default:
+		return r.LowerBound
+	}
+}
+
+func (p Policy) isIgnoredContainer(name string) bool {
+	return hasString(p.IgnoredContainers, name)
+}
+
+func (p Policy) adjustRequests() bool {
+	return p.Values != ControlledValuesLimits
+}
+
+func (p Policy) adjustLimits() bool {
+	return p.Values != ControlledValuesRequests
+}
+
+// vpaControlledValues maps the policy values to the VPA ones. The VPA has no limits only mode, limits
+// map to RequestsAndLimits and the requests recommendation is left unused
+func (p Policy) vpaControlledValues() vpav1.ContainerControlledValues {
+	if p.Values == ControlledValuesRequests {
+		return vpav1.ContainerControlledValuesRequestsOnly
+	}
+	return vpav1.ContainerControlledValuesRequestsAndLimits
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseResources reads tupyrae/controlled-resources, an unsupported name invalidates the whole value so
+// a typo never silently narrows the managed resources
+func parseResources(value string) ([]v1.ResourceName, error) {
+	resources := []v1.ResourceName{}
+	for _, item := range splitList(value) {
+		name := v1.ResourceName(item)
+		if !hasResource(managedResources, name) {
+			return nil, fmt.Errorf("ignoring tupyrae/controlled-resources %q, unsupported resource %s, expected cpu or memory", value, item)
+		}
+		resources = appendResource(resources, name)
+	}
+	return resources, nil
+}
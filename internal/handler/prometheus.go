@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/prometheus"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	SourceVpa        = "vpa"
+	SourcePrometheus = "prometheus"
+)
+
+// generatedAlphabet is the alphabet of the pod-template-hash and of the generated pod name suffixes
+const generatedAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+var promClient *prometheus.Client
+
+func getPrometheus() *prometheus.Client {
+	if promClient == nil {
+		promClient = prometheus.NewClient(config.Get().PrometheusURL)
+	}
+	return promClient
+}
+
+// prometheusRecommender computes the recommendations from the usage percentiles stored in Prometheus
+type prometheusRecommender struct {
+	client *prometheus.Client
+}
+
+func (r *prometheusRecommender) Name() string {
+	return SourcePrometheus
+}
+
+func (r *prometheusRecommender) Recommend(w *Workload) ([]Recommendation, error) {
+	return prometheusRecommendation(r.client, podSelector(w))
+}
+
+// prometheusRecommendation computes the container recommendations from the usage quantiles of
+// the series matching the selectors over the configured window. Each selector is queried on its
+// own and the highest values kept, like the series of a single query. The memory upper bound, used
+// as limit, gets the configured margin and is never below the peak usage
+func prometheusRecommendation(client *prometheus.Client, selectors ...string) ([]Recommendation, error) {
+	cfg := config.Get()
+	series := map[v1.ResourceName]string{
+		v1.ResourceCPU:    "rate(container_cpu_usage_seconds_total{%s}[5m])[%s:5m]",
+		v1.ResourceMemory: "container_memory_working_set_bytes{%s}[%s]",
+	}
+	quantiles := []float64{cfg.LowerQuantile, cfg.TargetQuantile, cfg.UpperQuantile}
+
+	containers := map[string]*Recommendation{}
+	for name, s := range series {
+		for i, q := range quantiles {
+			var samples []prometheus.Sample
+			for _, selector := range selectors {
+				query := fmt.Sprintf("max by (container) (quantile_over_time(%g, %s))", q, fmt.Sprintf(s, selector, cfg.PrometheusWindow))
+				result, err := client.Query(context.TODO(), query)
+				if err != nil {
+					return nil, err
+				}
+				samples = append(samples, result...)
+			}
+
+			for _, sample := range samples {
+				if !validSample(sample) {
+					continue
+				}
+				if name == v1.ResourceMemory && i == len(quantiles)-1 {
+					sample.Value *= 1 + cfg.MemoryMargin
+				}
+				container := sample.Labels["container"]
+				r, ok := containers[container]
+				if !ok {
+					r = &Recommendation{
+						ContainerName: container,
+						LowerBound:    v1.ResourceList{},
+						Target:        v1.ResourceList{},
+						UpperBound:    v1.ResourceList{},
+						Confidence:    1,
+						Source:        SourcePrometheus,
+					}
+					containers[container] = r
+				}
+				bound := []v1.ResourceList{r.LowerBound, r.Target, r.UpperBound}[i]
+				usage := usageQuantity(name, sample.Value)
+				if current, ok := bound[name]; !ok || usage.Cmp(current) > 0 {
+					bound[name] = usage
+				}
+			}
+		}
+	}
+
+	if len(containers) > 0 {
+		peaks, err := peakMemory(client, selectors...)
+		if err != nil {
+			return nil, err
+		}
+		for container, peak := range peaks {
+			if r, ok := containers[container]; ok {
+				if upper, ok := r.UpperBound[v1.ResourceMemory]; !ok || peak.Cmp(upper) > 0 {
+					r.UpperBound[v1.ResourceMemory] = peak
+				}
+			}
+		}
+	}
+
+	recommendations := make([]Recommendation, 0, len(containers))
+	for _, r := range containers {
+		recommendations = append(recommendations, *r)
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		return recommendations[i].ContainerName < recommendations[j].ContainerName
+	})
+
+	return recommendations, nil
+}
+
+// peakMemory returns the highest memory usage of each container matching the selectors over the window
+func peakMemory(client *prometheus.Client, selectors ...string) (map[string]resource.Quantity, error) {
+	peaks := map[string]resource.Quantity{}
+	for _, selector := range selectors {
+		query := fmt.Sprintf("max by (container) (max_over_time(container_memory_working_set_bytes{%s}[%s]))", selector, config.Get().PrometheusWindow)
+		samples, err := client.Query(context.TODO(), query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sample := range samples {
+			if !validSample(sample) {
+				continue
+			}
+			peak := usageQuantity(v1.ResourceMemory, sample.Value)
+			if current, ok := peaks[sample.Labels["container"]]; !ok || peak.Cmp(current) > 0 {
+				peaks[sample.Labels["container"]] = peak
+			}
+		}
+	}
+	return peaks, nil
+}
+
+// podSelector matches the series of the workload containers. The pods of a Deployment are named
+// after its ReplicaSets, suffixed by the pod-template-hash, the pods of a CronJob after its Jobs,
+// suffixed by the scheduled time, both followed by the random pod suffix. Anchoring on those formats
+// and the alphabet Kubernetes generates them from keeps out the pods of other workloads sharing the
+// prefix, such as StatefulSet pods
+func podSelector(w *Workload) string {
+	suffix := fmt.Sprintf("-[%s]{5,10}-[%s]{5}", generatedAlphabet, generatedAlphabet)
+	if w.Kind == "CronJob" {
+		suffix = fmt.Sprintf("-[0-9]+-[%s]{5}", generatedAlphabet)
+	}
+	return fmt.Sprintf(`namespace=%q,pod=~%q,container!="",container!="POD"`, w.Namespace, regexp.QuoteMeta(w.Name)+suffix)
+}
+
+// validSample drops the NaN and infinite values Prometheus returns for series without samples
+func validSample(sample prometheus.Sample) bool {
+	return !math.IsNaN(sample.Value) && !math.IsInf(sample.Value, 0)
+}
+
+// usageQuantity converts a usage sample, in cores or bytes, to a quantity
+func usageQuantity(name v1.ResourceName, value float64) resource.Quantity {
+	if name == v1.ResourceCPU {
+		return *resource.NewMilliQuantity(int64(math.Ceil(value*1000)), resource.DecimalSI)
+	}
+	return *resource.NewQuantity(int64(math.Ceil(value)), resource.BinarySI)
+}
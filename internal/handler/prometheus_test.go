@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"Tupyrae/internal/prometheus"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// stubPrometheus answers the queries with the vector of the first series the query contains, an
+// empty vector otherwise
+func stubPrometheus(t *testing.T, vectors map[string]string) *prometheus.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		result := "[]"
+		for series, vector := range vectors {
+			if strings.Contains(query, series) {
+				result = vector
+				break
+			}
+		}
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":%s}}`, result)
+	}))
+	t.Cleanup(server.Close)
+	return prometheus.NewClient(server.URL)
+}
+
+func TestPrometheusRecommendation(t *testing.T) {
+	client := stubPrometheus(t, map[string]string{
+		"quantile_over_time(0.5, rate(container_cpu":  `[{"metric":{"container":"app"},"value":[0,"0.1"]}]`,
+		"quantile_over_time(0.9, rate(container_cpu":  `[{"metric":{"container":"app"},"value":[0,"0.2"]}]`,
+		"quantile_over_time(0.99, rate(container_cpu": `[{"metric":{"container":"app"},"value":[0,"0.3004"]}]`,
+		"quantile_over_time(0.5, container_memory":    `[{"metric":{"container":"app"},"value":[0,"104857600"]}]`,
+		"quantile_over_time(0.9, container_memory":    `[{"metric":{"container":"app"},"value":[0,"209715200"]}]`,
+		"quantile_over_time(0.99, container_memory":   `[{"metric":{"container":"app"},"value":[0,"314572800"]}]`,
+		"max_over_time(container_memory":              `[{"metric":{"container":"app"},"value":[0,"335544320"]}]`,
+	})
+
+	recommendations, err := prometheusRecommendation(client, `namespace="default"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recommendations) != 1 {
+		t.Fatalf("got %d recommendations, want 1", len(recommendations))
+	}
+
+	r := recommendations[0]
+	if r.ContainerName != "app" || r.Source != SourcePrometheus {
+		t.Errorf("recommendation = %+v", r)
+	}
+	want := map[string]v1.ResourceList{
+		"lowerBound": {v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("100Mi")},
+		"target":     {v1.ResourceCPU: resource.MustParse("200m"), v1.ResourceMemory: resource.MustParse("200Mi")},
+		"upperBound": {v1.ResourceCPU: resource.MustParse("301m"), v1.ResourceMemory: resource.MustParse("345Mi")},
+	}
+	got := map[string]v1.ResourceList{"lowerBound": r.LowerBound, "target": r.Target, "upperBound": r.UpperBound}
+	for field, resources := range want {
+		for name, quantity := range resources {
+			if value := got[field][name]; value.Cmp(quantity) != 0 {
+				t.Errorf("%s %s = %s, want %s", field, name, value.String(), quantity.String())
+			}
+		}
+	}
+}
+
+func TestPrometheusRecommendationPeak(t *testing.T) {
+	client := stubPrometheus(t, map[string]string{
+		"quantile_over_time(0.99, container_memory": `[{"metric":{"container":"app"},"value":[0,"104857600"]},{"metric":{"container":"sidecar"},"value":[0,"NaN"]}]`,
+		"max_over_time(container_memory":            `[{"metric":{"container":"app"},"value":[0,"209715200"]},{"metric":{"container":"sidecar"},"value":[0,"+Inf"]}]`,
+	})
+
+	recommendations, err := prometheusRecommendation(client, `namespace="default"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recommendations) != 1 {
+		t.Fatalf("got %d recommendations, want 1", len(recommendations))
+	}
+	want := resource.MustParse("200Mi")
+	if upper := recommendations[0].UpperBound[v1.ResourceMemory]; upper.Cmp(want) != 0 {
+		t.Errorf("memory upper bound = %s, want the peak %s", upper.String(), want.String())
+	}
+}
+
+func TestPrometheusRecommendationEmpty(t *testing.T) {
+	client := stubPrometheus(t, nil)
+
+	recommendations, err := prometheusRecommendation(client, `namespace="default"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(recommendations) != 0 {
+		t.Errorf("got %d recommendations, want none", len(recommendations))
+	}
+}
+
+func TestPrometheusRecommendationError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"status":"error","error":"bad query"}`)
+	}))
+	defer server.Close()
+
+	if _, err := prometheusRecommendation(prometheus.NewClient(server.URL), `namespace="default"`); err == nil {
+		t.Error("expected an error")
+	}
+	if _, err := peakMemory(prometheus.NewClient(server.URL), `namespace="default"`); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestPeakMemory(t *testing.T) {
+	client := stubPrometheus(t, map[string]string{
+		"max_over_time(container_memory": `[{"metric":{"container":"app"},"value":[0,"536870912"]},{"metric":{"container":"sidecar"},"value":[0,"1000.5"]}]`,
+	})
+
+	peaks, err := peakMemory(client, `namespace="default"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]resource.Quantity{"app": resource.MustParse("512Mi"), "sidecar": resource.MustParse("1001")}
+	if len(peaks) != len(want) {
+		t.Fatalf("got %d peaks, want %d", len(peaks), len(want))
+	}
+	for container, quantity := range want {
+		if value := peaks[container]; value.Cmp(quantity) != 0 {
+			t.Errorf("peak of %s = %s, want %s", container, value.String(), quantity.String())
+		}
+	}
+}
+
+func TestPeakMemorySelectors(t *testing.T) {
+	client := stubPrometheus(t, map[string]string{
+		`pod=~"a"`: `[{"metric":{"container":"app"},"value":[0,"1024"]},{"metric":{"container":"sidecar"},"value":[0,"4096"]}]`,
+		`pod=~"b"`: `[{"metric":{"container":"app"},"value":[0,"2048"]}]`,
+	})
+
+	peaks, err := peakMemory(client, `pod=~"a"`, `pod=~"b"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]resource.Quantity{"app": resource.MustParse("2Ki"), "sidecar": resource.MustParse("4Ki")}
+	for container, quantity := range want {
+		if value := peaks[container]; value.Cmp(quantity) != 0 {
+			t.Errorf("peak of %s = %s, want %s", container, value.String(), quantity.String())
+		}
+	}
+}
+
+func TestPodSelector(t *testing.T) {
+	tests := []struct {
+		kind string
+		pod  string
+		want bool
+	}{
+		{"Deployment", "web-7d9f8b6c5d-x2k9p", true},
+		{"Deployment", "web-5d4b8-x2k9p", true},
+		{"Deployment", "web-db-0", false},
+		{"Deployment", "web-28914720-x2k9p", false},
+		{"Deployment", "web-api-7d9f8b6c5d-x2k9p", false},
+		{"CronJob", "web-28914720-x2k9p", true},
+		{"CronJob", "web-7d9f8b6c5d-x2k9p", false},
+	}
+
+	for _, tt := range tests {
+		selector := podSelector(&Workload{Kind: tt.kind, Name: "web", Namespace: "default"})
+		pattern := regexp.MustCompile(`pod=~"(.*?)"`).FindStringSubmatch(selector)[1]
+		pattern = strings.ReplaceAll(pattern, `\\`, `\`)
+		if got := regexp.MustCompile("^(?:" + pattern + ")$").MatchString(tt.pod); got != tt.want {
+			t.Errorf("%s selector %s matches %s = %v, want %v", tt.kind, selector, tt.pod, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// QosPolicyPreserve keeps the QoS class of the workload pods
+	QosPolicyPreserve = "preserve"
+	// QosPolicyAllow lets the adjustment change the QoS class
+	QosPolicyAllow = "allow"
+)
+
+// qosClass computes the QoS class of the pods created from the spec
+func qosClass(spec *v1.PodSpec) v1.PodQOSClass {
+	containers := append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+
+	bestEffort, guaranteed := true, true
+	for _, c := range containers {
+		for _, name := range managedResources {
+			request, hasRequest := c.Resources.Requests[name]
+			limit, hasLimit := c.Resources.Limits[name]
+			if (hasRequest && !request.IsZero()) || (hasLimit && !limit.IsZero()) {
+				bestEffort = false
+			}
+			// An unset request defaults to the limit
+			if !hasLimit || (hasRequest && request.Cmp(limit) != 0) {
+				guaranteed = false
+			}
+		}
+	}
+
+	switch {
+	case bestEffort:
+		return v1.PodQOSBestEffort
+	case guaranteed:
+		return v1.PodQOSGuaranteed
+	default:
+		return v1.PodQOSBurstable
+	}
+}
+
+// preserveQos keeps the QoS class the workload had before the adjustment, Guaranteed pods
+// get their limits set to the new requests, it returns false when the adjustment must be skipped.
+// BestEffort pods are never sized then, any request would make them Burstable
+func preserveQos(w *Workload, policy Policy, original *v1.PodSpec) bool {
+	if policy.Qos == QosPolicyAllow {
+		return true
+	}
+
+	before := qosClass(original)
+	if before == v1.PodQOSGuaranteed {
+		for _, c := range allContainers(w.PodSpec) {
+			guarantee(c)
+		}
+	}
+
+	if after := qosClass(w.PodSpec); after != before {
+		recordEventOnce(w, v1.EventTypeWarning, "QoSPreserved", fmt.Sprintf("adjustment skipped, it would change the QoS class from %s to %s", before, after))
+		return false
+	}
+
+	return true
+}
+
+func guarantee(c *v1.Container) {
+	for _, name := range managedResources {
+		if q, ok := c.Resources.Requests[name]; ok {
+			if c.Resources.Limits == nil {
+				c.Resources.Limits = v1.ResourceList{}
+			}
+			c.Resources.Limits[name] = q
+		}
+	}
+}
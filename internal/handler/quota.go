@@ -0,0 +1,252 @@
+package handler
+
+import (
+	"Tupyrae/internal/k8s"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/klog"
+)
+
+// quotaResources maps the ResourceQuota keys to the pod resource they limit
+var quotaResources = map[v1.ResourceName]struct {
+	name   v1.ResourceName
+	limits bool
+}{
+	v1.ResourceCPU:            {v1.ResourceCPU, false},
+	v1.ResourceMemory:         {v1.ResourceMemory, false},
+	v1.ResourceRequestsCPU:    {v1.ResourceCPU, false},
+	v1.ResourceRequestsMemory: {v1.ResourceMemory, false},
+	v1.ResourceLimitsCPU:      {v1.ResourceCPU, true},
+	v1.ResourceLimitsMemory:   {v1.ResourceMemory, true},
+}
+
+// validateResources clamps the new resources to the namespace LimitRanges and checks that the
+// ResourceQuotas have room for them, it returns false when the adjustment must be skipped
+func validateResources(w *Workload, original *v1.PodSpec) bool {
+	limitRanges, err := k8s.GetLimitRanges(w.Namespace)
+	if err != nil {
+		klog.Errorf("Error getting LimitRanges: %v", err)
+		return false
+	}
+
+	for _, lr := range limitRanges {
+		for _, item := range lr.Spec.Limits {
+			switch item.Type {
+			case v1.LimitTypeContainer:
+				for _, c := range allContainers(w.PodSpec) {
+					for _, msg := range clampContainer(c, item) {
+						recordEventOnce(w, v1.EventTypeNormal, "LimitRangeClamped", fmt.Sprintf("LimitRange %s: %s", lr.Name, msg))
+					}
+				}
+			case v1.LimitTypePod:
+				if err := checkPodLimits(w.PodSpec, item); err != nil {
+					recordEventOnce(w, v1.EventTypeWarning, "LimitRangeViolated", fmt.Sprintf("LimitRange %s: %v", lr.Name, err))
+					return false
+				}
+			}
+		}
+	}
+
+	quotas, err := k8s.GetResourceQuotas(w.Namespace)
+	if err != nil {
+		klog.Errorf("Error getting ResourceQuotas: %v", err)
+		return false
+	}
+
+	for _, quota := range quotas {
+		if !quotaSelects(quota, w.PodSpec) {
+			continue
+		}
+		if err := checkQuota(w, original, quota); err != nil {
+			recordEventOnce(w, v1.EventTypeWarning, "QuotaExceeded", fmt.Sprintf("ResourceQuota %s: %v", quota.Name, err))
+			return false
+		}
+	}
+
+	return true
+}
+
+func clampContainer(c *v1.Container, item v1.LimitRangeItem) []string {
+	var messages []string
+
+	for name, max := range item.Max {
+		if q, ok := c.Resources.Requests[name]; ok && q.Cmp(max) > 0 {
+			c.Resources.Requests[name] = max
+			messages = append(messages, fmt.Sprintf("%s request of %s lowered from %s to max %s", name, c.Name, q.String(), max.String()))
+		}
+		if q, ok := c.Resources.Limits[name]; ok && q.Cmp(max) > 0 {
+			c.Resources.Limits[name] = max
+			messages = append(messages, fmt.Sprintf("%s limit of %s lowered from %s to max %s", name, c.Name, q.String(), max.String()))
+		}
+	}
+
+	for name, min := range item.Min {
+		if q, ok := c.Resources.Requests[name]; ok && q.Cmp(min) < 0 {
+			c.Resources.Requests[name] = min
+			messages = append(messages, fmt.Sprintf("%s request of %s raised from %s to min %s", name, c.Name, q.String(), min.String()))
+		}
+		if q, ok := c.Resources.Limits[name]; ok && q.Cmp(min) < 0 {
+			c.Resources.Limits[name] = min
+			messages = append(messages, fmt.Sprintf("%s limit of %s raised from %s to min %s", name, c.Name, q.String(), min.String()))
+		}
+	}
+
+	for name, ratio := range item.MaxLimitRequestRatio {
+		request, hasRequest := c.Resources.Requests[name]
+		limit, hasLimit := c.Resources.Limits[name]
+		if !hasRequest || !hasLimit || request.IsZero() {
+			continue
+		}
+		if float64(limit.MilliValue())/float64(request.MilliValue()) > ratio.AsApproximateFloat64() {
+			raised := scaleQuantity(limit, 1/ratio.AsApproximateFloat64())
+			c.Resources.Requests[name] = raised
+			messages = append(messages, fmt.Sprintf("%s request of %s raised from %s to %s to respect the limit/request ratio %s", name, c.Name, request.String(), raised.String(), ratio.String()))
+		}
+	}
+
+	// A request can never be above its limit
+	for name, limit := range c.Resources.Limits {
+		if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) > 0 {
+			c.Resources.Requests[name] = limit
+		}
+	}
+
+	return messages
+}
+
+func checkPodLimits(spec *v1.PodSpec, item v1.LimitRangeItem) error {
+	requests, limits := podResources(spec)
+
+	for name, max := range item.Max {
+		if q, ok := requests[name]; ok && q.Cmp(max) > 0 {
+			return fmt.Errorf("pod %s request %s is above max %s", name, q.String(), max.String())
+		}
+		if q, ok := limits[name]; ok && q.Cmp(max) > 0 {
+			return fmt.Errorf("pod %s limit %s is above max %s", name, q.String(), max.String())
+		}
+	}
+
+	for name, min := range item.Min {
+		if q, ok := requests[name]; ok && q.Cmp(min) < 0 {
+			return fmt.Errorf("pod %s request %s is below min %s", name, q.String(), min.String())
+		}
+	}
+
+	return nil
+}
+
+// quotaSelects tells if the scopes of the quota select the pods created from the spec, a quota without
+// scope selects every pod
+func quotaSelects(quota v1.ResourceQuota, spec *v1.PodSpec) bool {
+	for _, scope := range quota.Spec.Scopes {
+		if !scopeSelects(v1.ScopedResourceSelectorRequirement{ScopeName: scope, Operator: v1.ScopeSelectorOpExists}, spec) {
+			return false
+		}
+	}
+	if quota.Spec.ScopeSelector != nil {
+		for _, req := range quota.Spec.ScopeSelector.MatchExpressions {
+			if !scopeSelects(req, spec) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func scopeSelects(req v1.ScopedResourceSelectorRequirement, spec *v1.PodSpec) bool {
+	switch req.ScopeName {
+	case v1.ResourceQuotaScopeTerminating:
+		return spec.ActiveDeadlineSeconds != nil
+	case v1.ResourceQuotaScopeNotTerminating:
+		return spec.ActiveDeadlineSeconds == nil
+	case v1.ResourceQuotaScopeBestEffort:
+		return qosClass(spec) == v1.PodQOSBestEffort
+	case v1.ResourceQuotaScopeNotBestEffort:
+		return qosClass(spec) != v1.PodQOSBestEffort
+	case v1.ResourceQuotaScopePriorityClass:
+		switch req.Operator {
+		case v1.ScopeSelectorOpExists:
+			return spec.PriorityClassName != ""
+		case v1.ScopeSelectorOpDoesNotExist:
+			return spec.PriorityClassName == ""
+		case v1.ScopeSelectorOpIn:
+			return hasString(req.Values, spec.PriorityClassName)
+		case v1.ScopeSelectorOpNotIn:
+			return !hasString(req.Values, spec.PriorityClassName)
+		}
+	case v1.ResourceQuotaScopeCrossNamespacePodAffinity:
+		return crossNamespaceAffinity(spec)
+	}
+	// An unknown scope is assumed to select the pod, checking the quota is the safe side
+	return true
+}
+
+// crossNamespaceAffinity tells if a pod affinity or anti-affinity term of the spec reaches other namespaces
+func crossNamespaceAffinity(spec *v1.PodSpec) bool {
+	if spec.Affinity == nil {
+		return false
+	}
+	var terms []v1.PodAffinityTerm
+	if a := spec.Affinity.PodAffinity; a != nil {
+		terms = append(terms, a.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, t := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, t.PodAffinityTerm)
+		}
+	}
+	if a := spec.Affinity.PodAntiAffinity; a != nil {
+		terms = append(terms, a.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, t := range a.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, t.PodAffinityTerm)
+		}
+	}
+	for _, t := range terms {
+		if len(t.Namespaces) > 0 || t.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// checkQuota verifies the quota can hold the extra resources, both once every replica
+// runs the new resources and during the rollout when the surge pods are created
+func checkQuota(w *Workload, original *v1.PodSpec, quota v1.ResourceQuota) error {
+	oldRequests, oldLimits := podResources(original)
+	newRequests, newLimits := podResources(w.PodSpec)
+	replicas, surge := w.scale()
+
+	for key, hard := range quota.Status.Hard {
+		r, ok := quotaResources[key]
+		if !ok {
+			continue
+		}
+
+		oldPod, newPod := oldRequests[r.name], newRequests[r.name]
+		if r.limits {
+			oldPod, newPod = oldLimits[r.name], newLimits[r.name]
+		}
+
+		needed := replicas * (newPod.MilliValue() - oldPod.MilliValue())
+		if rollout := surge * newPod.MilliValue(); rollout > needed {
+			needed = rollout
+		}
+		if needed <= 0 {
+			continue
+		}
+
+		used := quota.Status.Used[key]
+		available := hard.MilliValue() - used.MilliValue()
+		if needed > available {
+			return fmt.Errorf("%s needs %s more but only %s is left", key,
+				resource.NewMilliQuantity(needed, hard.Format).String(),
+				resource.NewMilliQuantity(available, hard.Format).String())
+		}
+	}
+
+	return nil
+}
+
+func scaleQuantity(q resource.Quantity, factor float64) resource.Quantity {
+	return *resource.NewMilliQuantity(int64(float64(q.MilliValue())*factor), q.Format)
+}
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	vpav1 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
+	"k8s.io/klog"
+)
+
+// Recommendation is the resources a Recommender suggests for a container. Requests are taken from
+// the bound selected by the policy, limits from the UpperBound
+type Recommendation struct {
+	ContainerName string
+	LowerBound    v1.ResourceList
+	Target        v1.ResourceList
+	UpperBound    v1.ResourceList
+	// Confidence goes from 0, a guess, to 1, adjustments wait for the configured minimum
+	Confidence float64
+	// Source names the recommender that produced the values
+	Source string
+}
+
+// Recommender computes resource recommendations for the containers of a workload
+type Recommender interface {
+	Name() string
+	Recommend(w *Workload) ([]Recommendation, error)
+}
+
+// getRecommender builds the recommender of the workload from the configured sources, several
+// sources are combined keeping the highest values. The VPA source uses the given VPA, or looks it
+// up when nil. CronJobs are sized from their history unless their policy says otherwise
+func getRecommender(w *Workload, vpa *vpav1.VerticalPodAutoscaler) Recommender {
+	recommender := sourceRecommender(vpa)
+	if w.Kind == "CronJob" && getPolicy(w).CronJobStrategy == CronJobStrategyHistory {
+		return &cronjobRecommender{base: recommender}
+	}
+	return recommender
+}
+
+func sourceRecommender(vpa *vpav1.VerticalPodAutoscaler) Recommender {
+	var recommenders []Recommender
+	for _, source := range splitList(config.Get().RecommendationSource) {
+		switch source {
+		case SourceVpa:
+			recommenders = append(recommenders, &vpaRecommender{vpa: vpa})
+		case SourcePrometheus:
+			recommenders = append(recommenders, &prometheusRecommender{client: getPrometheus()})
+		default:
+			klog.Errorf("Unknown recommendation source %s", source)
+		}
+	}
+
+	if len(recommenders) == 1 {
+		return recommenders[0]
+	}
+	return &maxRecommender{recommenders: recommenders}
+}
+
+// RecommenderRun periodically adjusts the managed workloads when the VPA events do not drive them
+func RecommenderRun() {
+	for _, w := range managedWorkloads("") {
+		if checkCache(w.Namespace, w.Name) {
+			continue
+		}
+
+		adjust(w, getRecommender(w, nil))
+	}
+}
+
+// managedWorkloads lists the workloads Tupyrae manages in the namespace, every namespace when empty
+func managedWorkloads(namespace string) []*Workload {
+	var workloads []*Workload
+	for _, deploy := range k8s.GetDeploys(namespace) {
+		workloads = append(workloads, workloadByDeployment(&deploy))
+	}
+	for _, cron := range k8s.GetCronJobs(namespace) {
+		workloads = append(workloads, workloadByCronJob(&cron))
+	}
+
+	var managed []*Workload
+	namespaces := map[string]*v1.Namespace{}
+	for _, w := range workloads {
+		if ns, ok := namespaces[w.Namespace]; ok {
+			w.ns = ns
+		} else {
+			namespaces[w.Namespace] = w.namespace()
+		}
+
+		if isManaged(w) {
+			managed = append(managed, w)
+		}
+	}
+	return managed
+}
+
+// UsesVpa tells if the VPA is one of the configured sources, then the VPA events drive the adjustments
+func UsesVpa() bool {
+	return usesSource(SourceVpa)
+}
+
+func usesSource(name string) bool {
+	return hasString(splitList(config.Get().RecommendationSource), name)
+}
+
+// lowConfidence returns the first recommendation of a managed container below the minimum confidence
+func lowConfidence(recommendations []Recommendation, policy Policy) (Recommendation, bool) {
+	for _, r := range recommendations {
+		if !policy.isIgnoredContainer(r.ContainerName) && r.Confidence < config.Get().MinConfidence {
+			return r, true
+		}
+	}
+	return Recommendation{}, false
+}
+
+// containerList returns the init containers and containers of the pod
+func containerList(spec *v1.PodSpec) []v1.Container {
+	return append(append([]v1.Container{}, spec.InitContainers...), spec.Containers...)
+}
+
+// vpaRecommender reads the recommendations from the status of the workload VPA
+type vpaRecommender struct {
+	vpa *vpav1.VerticalPodAutoscaler
+}
+
+func (r *vpaRecommender) Name() string {
+	return SourceVpa
+}
+
+func (r *vpaRecommender) Recommend(w *Workload) ([]Recommendation, error) {
+	vpa := r.vpa
+	if vpa == nil {
+		ns := w.namespace()
+		if ns == nil {
+			return nil, fmt.Errorf("Namespace %s not found", w.Namespace)
+		}
+		vpas := mapperVpa(ns)
+		found, ok := vpas[getKey(w)]
+		if !ok {
+			return nil, nil
+		}
+		vpa = &found
+	}
+
+	if vpa.Status.Recommendation == nil {
+		return nil, nil
+	}
+
+	confidence := 1.0
+	for _, c := range vpa.Status.Conditions {
+		if c.Type == vpav1.LowConfidence && c.Status == v1.ConditionTrue {
+			confidence = 0.5
+		}
+	}
+
+	var recommendations []Recommendation
+	for _, c := range vpa.Status.Recommendation.ContainerRecommendations {
+		recommendations = append(recommendations, Recommendation{
+			ContainerName: c.ContainerName,
+			LowerBound:    c.LowerBound,
+			Target:        c.Target,
+			UpperBound:    c.UpperBound,
+			Confidence:    confidence,
+			Source:        vpaSource(vpa),
+		})
+	}
+	return recommendations, nil
+}
+
+// maxRecommender combines recommenders keeping, per container, the highest value of each bound
+type maxRecommender struct {
+	recommenders []Recommender
+}
+
+func (r *maxRecommender) Name() string {
+	names := make([]string, 0, len(r.recommenders))
+	for _, recommender := range r.recommenders {
+		names = append(names, recommender.Name())
+	}
+	return fmt.Sprintf("max(%s)", strings.Join(names, ","))
+}
+
+func (r *maxRecommender) Recommend(w *Workload) ([]Recommendation, error) {
+	combined := map[string]*Recommendation{}
+	for _, recommender := range r.recommenders {
+		recommendations, err := recommender.Recommend(w)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", recommender.Name(), err)
+		}
+
+		for _, rec := range recommendations {
+			current, ok := combined[rec.ContainerName]
+			if !ok {
+				copied := Recommendation{
+					ContainerName: rec.ContainerName,
+					LowerBound:    rec.LowerBound.DeepCopy(),
+					Target:        rec.Target.DeepCopy(),
+					UpperBound:    rec.UpperBound.DeepCopy(),
+					Confidence:    rec.Confidence,
+					Source:        rec.Source,
+				}
+				combined[rec.ContainerName] = &copied
+				continue
+			}
+
+			current.LowerBound = maxResourceList(current.LowerBound, rec.LowerBound)
+			current.Target = maxResourceList(current.Target, rec.Target)
+			current.UpperBound = maxResourceList(current.UpperBound, rec.UpperBound)
+			current.Confidence = min(current.Confidence, rec.Confidence)
+			current.Source = current.Source + "+" + rec.Source
+		}
+	}
+
+	recommendations := make([]Recommendation, 0, len(combined))
+	for _, rec := range combined {
+		recommendations = append(recommendations, *rec)
+	}
+	sort.Slice(recommendations, func(i, j int) bool {
+		return recommendations[i].ContainerName < recommendations[j].ContainerName
+	})
+	return recommendations, nil
+}
+
+func maxResourceList(a v1.ResourceList, b v1.ResourceList) v1.ResourceList {
+	result := a.DeepCopy()
+	if result == nil {
+		result = v1.ResourceList{}
+	}
+	maxResources(result, b)
+	return result
+}
+
+// sources returns the distinct sources of the recommendations
+func sources(recommendations []Recommendation) string {
+	var names []string
+	for _, rec := range recommendations {
+		if !hasString(names, rec.Source) {
+			names = append(names, rec.Source)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func hasString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
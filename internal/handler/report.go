@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"math"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog"
+)
+
+const (
+	// StateWouldAdjust tells the report found an adjustment Tupyrae would apply right away
+	StateWouldAdjust = "WouldAdjust"
+	// ReasonCooldown tells the workload was evaluated recently, it is not evaluated again before the cooldown ends
+	ReasonCooldown = "Cooldown"
+)
+
+// WorkloadReport is the state of a managed workload as the report command prints it
+type WorkloadReport struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Mode      string `json:"mode"`
+
+	// WouldAdjust tells if Tupyrae would adjust the workload now, State, Reason and Message tell why not
+	WouldAdjust bool   `json:"wouldAdjust"`
+	State       string `json:"state"`
+	Reason      string `json:"reason,omitempty"`
+	Message     string `json:"message,omitempty"`
+	Source      string `json:"source,omitempty"`
+
+	Containers []ContainerReport `json:"containers,omitempty"`
+	// Provisioning is, per resource, how much the requests of the containers exceed their target in
+	// percent, negative when under-provisioned
+	Provisioning map[v1.ResourceName]float64 `json:"provisioning,omitempty"`
+
+	Pool       string  `json:"pool,omitempty"`
+	Replicas   int64   `json:"replicas"`
+	HourlyCost float64 `json:"hourlyCost"`
+	// HourlyCostDelta is the change since the oldest revision kept in the history, negative when saving
+	HourlyCostDelta float64          `json:"hourlyCostDelta"`
+	Adjustments     []AdjustmentCost `json:"adjustments,omitempty"`
+	// ProposedHourlyCostDelta is the change the adjustment Tupyrae computed would bring
+	ProposedHourlyCostDelta float64 `json:"proposedHourlyCostDelta,omitempty"`
+}
+
+// ContainerReport compares the resources of a container with its recommendation
+type ContainerReport struct {
+	Name       string          `json:"name"`
+	Requests   v1.ResourceList `json:"requests,omitempty"`
+	Limits     v1.ResourceList `json:"limits,omitempty"`
+	LowerBound v1.ResourceList `json:"lowerBound,omitempty"`
+	Target     v1.ResourceList `json:"target,omitempty"`
+	UpperBound v1.ResourceList `json:"upperBound,omitempty"`
+	// Proposed is the resources Tupyrae computed, empty when it leaves the container alone
+	Proposed     *v1.ResourceRequirements    `json:"proposed,omitempty"`
+	Provisioning map[v1.ResourceName]float64 `json:"provisioning,omitempty"`
+}
+
+// AdjustmentCost is the change of the hourly cost of an adjustment kept in the history
+type AdjustmentCost struct {
+	Time            time.Time `json:"time"`
+	HourlyCostDelta float64   `json:"hourlyCostDelta"`
+}
+
+// Report evaluates the managed workloads of the namespace and kind, every one when empty, the way
+// adjust does without committing nor recording events. The costs of the adjustments come from the
+// history annotation, at the current replicas
+func Report(namespace string, kind string) []WorkloadReport {
+	dryRun = true
+
+	var reports []WorkloadReport
+	for _, w := range managedWorkloads(namespace) {
+		if kind != "" && w.Kind != kind {
+			continue
+		}
+		reports = append(reports, workloadReport(w))
+	}
+	return reports
+}
+
+func workloadReport(w *Workload) WorkloadReport {
+	replicas, _ := w.scale()
+	policy := getPolicy(w)
+	current := w.PodSpec.DeepCopy()
+	report := WorkloadReport{
+		Kind:       w.Kind,
+		Namespace:  w.Namespace,
+		Name:       w.Name,
+		Mode:       policy.Mode,
+		Pool:       workloadPool(current),
+		Replicas:   replicas,
+		HourlyCost: roundCost(hourlyCost(w, current)),
+	}
+	reportHistory(w, &report)
+
+	ev := &evaluation{policy: policy, current: current}
+	if _, planned := plan(w, getRecommender(w, nil), ev); planned {
+		reportDecision(w, policy, ev)
+		report.ProposedHourlyCostDelta = roundCost(costDelta(w, current))
+	}
+	report.WouldAdjust = ev.state == StateWouldAdjust
+	report.State, report.Reason, report.Message = ev.state, ev.reason, ev.message
+	report.Source = sources(ev.recommendations)
+
+	proposed := specResources(w.PodSpec)
+	totalRequests, totalTargets := v1.ResourceList{}, v1.ResourceList{}
+	for _, c := range allContainers(current) {
+		container := ContainerReport{
+			Name:     c.Name,
+			Requests: c.Resources.Requests,
+			Limits:   c.Resources.Limits,
+		}
+		for _, r := range ev.recommendations {
+			if r.ContainerName != c.Name {
+				continue
+			}
+			container.LowerBound, container.Target, container.UpperBound = r.LowerBound, r.Target, r.UpperBound
+			container.Provisioning = provisioning(c.Resources.Requests, r.Target)
+			for name := range container.Provisioning {
+				addResources(totalRequests, v1.ResourceList{name: c.Resources.Requests[name]})
+				addResources(totalTargets, v1.ResourceList{name: r.Target[name]})
+			}
+		}
+		if after, ok := proposed[c.Name]; ok && !equality.Semantic.DeepEqual(after, c.Resources) {
+			container.Proposed = &after
+		}
+		report.Containers = append(report.Containers, container)
+	}
+	report.Provisioning = provisioning(totalRequests, totalTargets)
+
+	return report
+}
+
+// reportDecision tells what adjust would do with the planned adjustment, from the same decision,
+// without doing it
+func reportDecision(w *Workload, policy Policy, ev *evaluation) {
+	if inCooldown(w) {
+		ev.set(StatePending, ReasonCooldown, "Evaluated recently, waiting for the cooldown to end")
+		return
+	}
+
+	g, err := decide(policy, time.Now())
+	if err != nil {
+		ev.set(StateBlocked, ReasonWindow, err.Error())
+		return
+	}
+	switch g {
+	case gateRecommend:
+		ev.set(StateRecommended, ReasonRecommendMode, describeResources(w.PodSpec))
+	case gateApproval:
+		ev.set(StatePending, ReasonApproval, "Would propose a TupyraeChange: "+describeResources(w.PodSpec))
+	case gateWindow:
+		ev.set(StatePending, ReasonWindow, describeResources(w.PodSpec))
+	default:
+		ev.set(StateWouldAdjust, "", describeResources(w.PodSpec))
+	}
+}
+
+// inCooldown reads the cooldown from the TupyraeRecommendation, the cache of the controller being out of reach
+func inCooldown(w *Workload) bool {
+	if !config.Get().Status {
+		return false
+	}
+	rec, err := k8s.GetRecommendation(w.Namespace, recommendationName(w))
+	if err != nil {
+		return false
+	}
+	return rec.Status.CooldownUntil != nil && rec.Status.CooldownUntil.After(time.Now())
+}
+
+// provisioning returns, per resource with a request and a target, how much the request exceeds the
+// target in percent
+func provisioning(requests v1.ResourceList, target v1.ResourceList) map[v1.ResourceName]float64 {
+	percents := map[v1.ResourceName]float64{}
+	for _, name := range managedResources {
+		request, ok := requests[name]
+		value, ok2 := target[name]
+		if !ok || !ok2 || value.IsZero() {
+			continue
+		}
+		percent := float64(request.MilliValue()-value.MilliValue()) / float64(value.MilliValue()) * 100
+		percents[name] = math.Round(percent*10) / 10
+	}
+	if len(percents) == 0 {
+		return nil
+	}
+	return percents
+}
+
+// reportHistory adds the cost of the adjustments kept in the history of the workload
+func reportHistory(w *Workload, report *WorkloadReport) {
+	history, err := readHistory(w.Meta)
+	if err != nil {
+		klog.Errorf("Invalid %s of %s %s/%s: %v", historyKey, w.Kind, w.Namespace, w.Name, err)
+		return
+	}
+
+	// Each revision holds the resources before an adjustment, the next more recent one or the
+	// current resources are the resources after it
+	current := hourlyCost(w, w.PodSpec)
+	after := current
+	for _, rev := range history {
+		spec := w.PodSpec.DeepCopy()
+		injectResources(spec, rev.Containers)
+		before := hourlyCost(w, spec)
+		report.Adjustments = append(report.Adjustments, AdjustmentCost{Time: rev.Time, HourlyCostDelta: roundCost(after - before)})
+		report.HourlyCostDelta = roundCost(current - before)
+		after = before
+	}
+}
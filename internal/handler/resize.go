@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"Tupyrae/internal/k8s"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+const (
+	// podResizePending is the pod condition of the resizes the kubelet cannot apply yet, since 1.33
+	podResizePending = "PodResizePending"
+)
+
+// resizeCache remembers the resize statuses recently reported, the pod keeps them until resized
+var resizeCache = cache.New(DefaultExpiration, 30*time.Minute)
+
+// resizePods resizes the running pods of the workload to its adjusted template, which is left
+// untouched, and saves the resources in the resources annotation for the pods created later. It
+// updates the template instead when a container would restart, the QoS class would change or the
+// cluster has no resize subresource
+func resizePods(w *Workload) error {
+	pods, err := workloadPods(w)
+	if err != nil {
+		return err
+	}
+
+	patches := map[string][]byte{}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		patch, restart := resizePatch(pod, w.PodSpec)
+		if restart {
+			klog.Infof("Resizing %s %s/%s needs a restart, updating its template", w.Kind, w.Namespace, w.Name)
+			return updateTemplate(w)
+		}
+		if patch != nil {
+			patches[pod.Name] = patch
+		}
+	}
+
+	for name, patch := range patches {
+		if _, err := k8s.ResizePod(w.Namespace, name, patch); err != nil {
+			klog.Errorf("Error resizing Pod %s/%s, updating the template of %s %s: %v", w.Namespace, name, w.Kind, w.Name, err)
+			return updateTemplate(w)
+		}
+	}
+
+	klog.Infof("Resized %d pods of %s %s/%s in place", len(patches), w.Kind, w.Namespace, w.Name)
+	if err := annotateResources(w); err != nil {
+		return err
+	}
+	rememberApplied(w)
+	return nil
+}
+
+// updateTemplate writes the resources to the template, which the pods get from then on
+func updateTemplate(w *Workload) error {
+	setAnnotations(w.Meta, map[string]*string{resourcesKey: nil})
+	if err := w.Update(); err != nil {
+		return err
+	}
+	rememberApplied(w)
+	return nil
+}
+
+// resizeNewPod resizes a pod started from the template of a workload resized in place, the
+// template still having the resources from before the resizes
+func resizeNewPod(pod *v1.Pod) {
+	if pod.Status.Phase != v1.PodRunning || pod.DeletionTimestamp != nil {
+		return
+	}
+	w, resources := podApplied(pod)
+	if w == nil {
+		return
+	}
+
+	spec := pod.Spec.DeepCopy()
+	injectResources(spec, resources)
+	patch, restart := resizePatch(pod, spec)
+	if restart {
+		recordEventOnce(w, v1.EventTypeWarning, "ResizeSkipped", fmt.Sprintf("Pod %s runs the template resources, resizing it needs a restart", pod.Name))
+		return
+	}
+	if patch == nil {
+		return
+	}
+
+	if _, err := k8s.ResizePod(pod.Namespace, pod.Name, patch); err != nil {
+		klog.Errorf("Error resizing Pod %s/%s of %s %s: %v", pod.Namespace, pod.Name, w.Kind, w.Name, err)
+		return
+	}
+	klog.Infof("Resized Pod %s/%s to the resources of %s %s", pod.Namespace, pod.Name, w.Kind, w.Name)
+}
+
+// workloadPods returns the pods of the workload
+func workloadPods(w *Workload) ([]v1.Pod, error) {
+	switch item := w.Item.(type) {
+	case *appsv1.Deployment:
+		selector, err := metav1.LabelSelectorAsSelector(item.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		return k8s.GetPods(w.Namespace, selector.String())
+	case *batchv1.CronJob:
+		jobs, err := ownedJobs(w)
+		if err != nil {
+			return nil, err
+		}
+		return jobPods(w.Namespace, jobs)
+	}
+	return nil, fmt.Errorf("Unsupported kind: %s", w.Kind)
+}
+
+// resizePatch returns the patch of the containers of the pod whose resources differ from the
+// spec, and whether applying it restarts a container. Classic init containers have already run
+func resizePatch(pod *v1.Pod, spec *v1.PodSpec) ([]byte, bool) {
+	if qosClass(&pod.Spec) != qosClass(spec) {
+		return nil, true
+	}
+
+	var containers, initContainers []map[string]interface{}
+	for _, c := range allContainers(spec) {
+		running, initContainer := runningContainer(pod, c.Name)
+		if running == nil || (initContainer && !isSidecar(running)) {
+			continue
+		}
+		if equality.Semantic.DeepEqual(running.Resources, c.Resources) {
+			continue
+		}
+
+		for _, name := range changedResources(running.Resources, c.Resources) {
+			if resizeRestartPolicy(running, name) == v1.RestartContainer {
+				return nil, true
+			}
+		}
+
+		entry := map[string]interface{}{"name": c.Name, "resources": c.Resources}
+		if initContainer {
+			initContainers = append(initContainers, entry)
+		} else {
+			containers = append(containers, entry)
+		}
+	}
+
+	if len(containers) == 0 && len(initContainers) == 0 {
+		return nil, false
+	}
+
+	patchSpec := map[string]interface{}{}
+	if len(containers) > 0 {
+		patchSpec["containers"] = containers
+	}
+	if len(initContainers) > 0 {
+		patchSpec["initContainers"] = initContainers
+	}
+	patch, err := json.Marshal(map[string]interface{}{"spec": patchSpec})
+	if err != nil {
+		return nil, true
+	}
+	return patch, false
+}
+
+func runningContainer(pod *v1.Pod, name string) (*v1.Container, bool) {
+	if c := findContainer(pod.Spec.Containers, name); c != nil {
+		return c, false
+	}
+	if c := findContainer(pod.Spec.InitContainers, name); c != nil {
+		return c, true
+	}
+	return nil, false
+}
+
+// changedResources returns the resources whose request or limit differ
+func changedResources(current v1.ResourceRequirements, desired v1.ResourceRequirements) []v1.ResourceName {
+	var changed []v1.ResourceName
+	for _, lists := range [][2]v1.ResourceList{{current.Requests, desired.Requests}, {current.Limits, desired.Limits}} {
+		for name, value := range lists[1] {
+			if cur, ok := lists[0][name]; !ok || cur.Cmp(value) != 0 {
+				changed = appendResource(changed, name)
+			}
+		}
+	}
+	return changed
+}
+
+// resizeRestartPolicy returns the resize policy of the resource, NotRequired when unset
+func resizeRestartPolicy(c *v1.Container, name v1.ResourceName) v1.ResourceResizeRestartPolicy {
+	for _, p := range c.ResizePolicy {
+		if p.ResourceName == name {
+			return p.RestartPolicy
+		}
+	}
+	return v1.NotRequired
+}
+
+// resizeStatus returns Deferred or Infeasible when the kubelet cannot apply the resize of the pod
+func resizeStatus(pod *v1.Pod) (v1.PodResizeStatus, string) {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == podResizePending && c.Status == v1.ConditionTrue {
+			return v1.PodResizeStatus(c.Reason), c.Message
+		}
+	}
+	switch pod.Status.Resize {
+	case v1.PodResizeStatusDeferred, v1.PodResizeStatusInfeasible:
+		return pod.Status.Resize, ""
+	}
+	return "", ""
+}
+
+// reportResize records an event on the workload when the resize of one of its pods is pending
+func reportResize(pod *v1.Pod) {
+	status, message := resizeStatus(pod)
+	if status == "" {
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", pod.UID, status)
+	if _, found := resizeCache.Get(key); found {
+		return
+	}
+	resizeCache.Set(key, true, cache.DefaultExpiration)
+
+	w, err := getPodOwner(pod)
+	if err != nil || w == nil {
+		return
+	}
+
+	eventType := v1.EventTypeNormal
+	if status == v1.PodResizeStatusInfeasible {
+		eventType = v1.EventTypeWarning
+	}
+	klog.Infof("Resize of Pod %s/%s is %s: %s", pod.Namespace, pod.Name, status, message)
+	recordEvent(w, eventType, "Resize"+string(status), fmt.Sprintf("Pod %s: %s", pod.Name, message))
+}
@@ -28,10 +28,12 @@ func Checker(r *Resource) error {
 		if _, ok := r.Item.(*appsv1.Deployment); !ok {
 			return fmt.Errorf("Item is not a Deployment")
 		}
+		DeployRun(*r)
 	case "CronJob":
 		if _, ok := r.Item.(*batchv1.CronJob); !ok {
 			return fmt.Errorf("Item is not a CronJob")
 		}
+		CronJobRun(*r)
 	case "Namespace":
 		if _, ok := r.Item.(*corev1.Namespace); !ok {
 			return fmt.Errorf("Item is not a Namespace")
@@ -42,6 +44,11 @@ func Checker(r *Resource) error {
 			return fmt.Errorf("Item is not a VPA")
 		}
 		VpaRun(*r)
+	case "Pod":
+		if _, ok := r.Item.(*corev1.Pod); !ok {
+			return fmt.Errorf("Item is not a Pod")
+		}
+		PodRun(*r)
 	}
 
 	return nil
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/k8s"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// States of the last evaluation of a workload
+const (
+	StateAdjusted         = "Adjusted"
+	StateRecommended      = "Recommended"
+	StatePending          = "Pending"
+	StateBlocked          = "Blocked"
+	StateUpToDate         = "UpToDate"
+	StateDelegated        = "Delegated"
+	StateNoRecommendation = "NoRecommendation"
+	StateFailed           = "Failed"
+)
+
+// Reasons of a pending, blocked or failed adjustment
+const (
+	ReasonAutoVpa       = "AutoVpa"
+	ReasonRecommender   = "Recommender"
+	ReasonHpa           = "HpaConflict"
+	ReasonQos           = "QoS"
+	ReasonQuota         = "Quota"
+	ReasonRecommendMode = "RecommendMode"
+	ReasonApproval      = "Approval"
+	ReasonWindow        = "MaintenanceWindow"
+	ReasonOutput        = "Output"
+	ReasonPaused        = "Paused"
+	ReasonConfidence    = "LowConfidence"
+)
+
+const (
+	ResultSucceeded = "Succeeded"
+	ResultFailed    = "Failed"
+)
+
+// statusRefresh is the age after which an unchanged status is written again, for lastEvaluation
+const statusRefresh = DefaultExpiration
+
+// evaluation is the outcome of adjust, published in the TupyraeRecommendation of the workload
+type evaluation struct {
+	state   string
+	reason  string
+	message string
+
+	policy          Policy
+	current         *v1.PodSpec
+	recommendations []Recommendation
+	adjustment      *k8s.AdjustmentStatus
+	cost            *k8s.CostStatus
+	// cooldown tells the workload is not evaluated again before the cache expires
+	cooldown bool
+}
+
+func (e *evaluation) set(state string, reason string, message string) {
+	e.state = state
+	e.reason = reason
+	e.message = message
+}
+
+func (e *evaluation) adjusted(result string, message string, delta float64) {
+	e.adjustment = &k8s.AdjustmentStatus{Time: metav1.Now(), Result: result, Message: message, HourlyCostDelta: roundCost(delta)}
+}
+
+// status builds the status of the evaluation, the last adjustment is kept from the previous one
+func (e *evaluation) status(previous k8s.TupyraeRecommendationStatus) k8s.TupyraeRecommendationStatus {
+	now := metav1.Now()
+	status := k8s.TupyraeRecommendationStatus{
+		State:          e.state,
+		Reason:         e.reason,
+		Message:        e.message,
+		Source:         sources(e.recommendations),
+		LastEvaluation: &now,
+		LastAdjustment: previous.LastAdjustment,
+		Cost:           e.cost,
+		Policy: k8s.PolicyStatus{
+			Mode:              e.policy.Mode,
+			Output:            config.Get().Output,
+			Values:            e.policy.Values,
+			Recommendation:    e.policy.Recommendation,
+			Threshold:         e.policy.Threshold,
+			Hpa:               e.policy.Hpa,
+			Qos:               e.policy.Qos,
+			MaintenanceWindow: e.policy.MaintenanceWindow,
+			Approval:          e.policy.Approval,
+		},
+	}
+	for _, name := range e.policy.Resources {
+		status.Policy.Resources = append(status.Policy.Resources, string(name))
+	}
+	if e.adjustment != nil {
+		status.LastAdjustment = e.adjustment
+	}
+	if e.cooldown {
+		until := metav1.NewTime(now.Add(DefaultExpiration))
+		status.CooldownUntil = &until
+	} else if previous.CooldownUntil != nil && previous.CooldownUntil.After(now.Time) {
+		status.CooldownUntil = previous.CooldownUntil
+	}
+
+	containers := map[string]*k8s.ContainerStatus{}
+	for _, c := range allContainers(e.current) {
+		containers[c.Name] = &k8s.ContainerStatus{Name: c.Name, Resources: *c.Resources.DeepCopy()}
+	}
+	// Applying an approved change does not compute a recommendation, the last one still holds
+	if len(e.recommendations) == 0 {
+		status.Source = previous.Source
+		for _, p := range previous.Containers {
+			if c, ok := containers[p.Name]; ok {
+				c.LowerBound, c.Target, c.UpperBound = p.LowerBound, p.Target, p.UpperBound
+				c.Confidence = p.Confidence
+			}
+		}
+	}
+	for _, r := range e.recommendations {
+		if c, ok := containers[r.ContainerName]; ok {
+			c.LowerBound = r.LowerBound.DeepCopy()
+			c.Target = r.Target.DeepCopy()
+			c.UpperBound = r.UpperBound.DeepCopy()
+			c.Confidence = r.Confidence
+		}
+	}
+	for _, c := range containers {
+		status.Containers = append(status.Containers, *c)
+	}
+	sort.Slice(status.Containers, func(i, j int) bool {
+		return status.Containers[i].Name < status.Containers[j].Name
+	})
+
+	return status
+}
+
+// recommendationName names the TupyraeRecommendation of the workload
+func recommendationName(w *Workload) string {
+	return strings.ToLower(w.Kind) + "-" + w.Name
+}
+
+// publishStatus writes the evaluation to the TupyraeRecommendation of the workload, created owned by
+// the workload so that it is deleted with it, and updates the cost metric of the workload
+func publishStatus(w *Workload, ev *evaluation) {
+	observeCost(w, ev.current)
+	if !config.Get().Status {
+		return
+	}
+
+	name := recommendationName(w)
+	rec, err := k8s.GetRecommendation(w.Namespace, name)
+	if errors.IsNotFound(err) {
+		rec, err = k8s.CreateRecommendation(&k8s.TupyraeRecommendation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: w.Namespace,
+				Labels:    map[string]string{ownerLabel: owner},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: w.APIVersion,
+					Kind:       w.Kind,
+					Name:       w.Name,
+					UID:        w.Meta.UID,
+				}},
+			},
+			Spec: k8s.TupyraeRecommendationSpec{
+				WorkloadRef: k8s.WorkloadRef{APIVersion: w.APIVersion, Kind: w.Kind, Name: w.Name},
+			},
+		})
+	}
+	if err != nil {
+		klog.Errorf("Error getting TupyraeRecommendation %s/%s: %v", w.Namespace, name, err)
+		return
+	}
+
+	ev.cost = costStatus(w, ev.current)
+	status := ev.status(rec.Status)
+	if sameStatus(rec.Status, status) {
+		return
+	}
+
+	rec.Status = status
+	if err := k8s.UpdateRecommendationStatus(rec); err != nil {
+		klog.Errorf("Error updating TupyraeRecommendation %s/%s: %v", w.Namespace, name, err)
+	}
+}
+
+// sameStatus tells if the status only differs by a recent lastEvaluation
+func sameStatus(previous k8s.TupyraeRecommendationStatus, status k8s.TupyraeRecommendationStatus) bool {
+	if previous.LastEvaluation == nil || time.Since(previous.LastEvaluation.Time) > statusRefresh {
+		return false
+	}
+	previous.LastEvaluation = status.LastEvaluation
+	return equality.Semantic.DeepEqual(previous, status)
+}
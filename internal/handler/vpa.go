@@ -1,13 +1,15 @@
 package handler
 
 import (
-	"Tupyrae/internal/k8s"
+	"Tupyrae/internal/config"
 	"fmt"
 	"math"
+	"strings"
 	"time"
 
 	"github.com/patrickmn/go-cache"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	vpav1 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/apis/autoscaling.k8s.io/v1"
 	"k8s.io/klog"
 )
@@ -30,23 +32,35 @@ func VpaRun(r Resource) error {
 	return nil
 }
 
-func keyCache(vpa *vpav1.VerticalPodAutoscaler) string {
-	return vpa.Namespace + "/" + vpa.Spec.TargetRef.Name
+func keyCache(namespace string, name string) string {
+	return namespace + "/" + name
 }
 
-func checkCache(vpa *vpav1.VerticalPodAutoscaler) bool {
-	if _, found := resourcesCache.Get(keyCache(vpa)); found {
+func checkCache(namespace string, name string) bool {
+	if _, found := resourcesCache.Get(keyCache(namespace, name)); found {
 		return true
 	}
 	return false
 }
 
-func setCache(vpa *vpav1.VerticalPodAutoscaler) {
-	resourcesCache.Set(keyCache(vpa), true, DefaultExpiration)
+func setCache(namespace string, name string) {
+	resourcesCache.Set(keyCache(namespace, name), true, DefaultExpiration)
 }
 
 func checkVpa(vpa *vpav1.VerticalPodAutoscaler) {
-	if checkCache(vpa) {
+	if !isOwned(vpa) && !isAdopted(vpa) {
+		return
+	}
+
+	// The VPA updater already resizes the pods of VPAs in any other mode
+	if mode := updateMode(vpa); mode != vpav1.UpdateModeOff {
+		if !isOwned(vpa) {
+			klog.Infof("Ignoring adopted VPA %s/%s in %s mode", vpa.Namespace, vpa.Name, mode)
+		}
+		return
+	}
+
+	if checkCache(vpa.Namespace, vpa.Spec.TargetRef.Name) {
 		return
 	}
 
@@ -60,6 +74,26 @@ func checkVpa(vpa *vpav1.VerticalPodAutoscaler) {
 	}
 }
 
+// updateMode returns the update mode of the VPA, Auto when unset
+func updateMode(vpa *vpav1.VerticalPodAutoscaler) vpav1.UpdateMode {
+	if vpa.Spec.UpdatePolicy == nil || vpa.Spec.UpdatePolicy.UpdateMode == nil {
+		return vpav1.UpdateModeAuto
+	}
+	return *vpa.Spec.UpdatePolicy.UpdateMode
+}
+
+// vpaSource names the VPA recommenders computing the recommendation
+func vpaSource(vpa *vpav1.VerticalPodAutoscaler) string {
+	if len(vpa.Spec.Recommenders) == 0 {
+		return "vpa/default"
+	}
+	names := make([]string, 0, len(vpa.Spec.Recommenders))
+	for _, r := range vpa.Spec.Recommenders {
+		names = append(names, r.Name)
+	}
+	return "vpa/" + strings.Join(names, ",")
+}
+
 func isIgnored(annotation map[string]string) bool {
 	if _, ok := annotation["tupyrae/ignore"]; ok {
 		return true
@@ -68,122 +102,284 @@ func isIgnored(annotation map[string]string) bool {
 }
 
 func deployAdjust(vpa *vpav1.VerticalPodAutoscaler) {
-	deploy, err := k8s.GetDeploy(vpa.Namespace, vpa.Spec.TargetRef.Name)
+	w, err := getWorkload(vpa.Namespace, "Deployment", vpa.Spec.TargetRef.Name)
 	if err != nil {
 		klog.Error(err)
 		return
 	}
 
-	if isIgnored(deploy.Annotations) {
-		klog.Infof("Ignoring %s/%s", vpa.Namespace, vpa.Spec.TargetRef.Name)
+	adjust(w, getRecommender(w, vpa))
+}
+
+func cronjobAdjust(vpa *vpav1.VerticalPodAutoscaler) {
+	w, err := getWorkload(vpa.Namespace, "CronJob", vpa.Spec.TargetRef.Name)
+	if err != nil {
+		klog.Error(err)
 		return
 	}
 
-	var updated bool = false
-	if vpa.Status.Recommendation == nil || vpa.Status.Recommendation.ContainerRecommendations == nil || len(vpa.Status.Recommendation.ContainerRecommendations) == 0 {
-		klog.Infof("No recommendation for %s/%s yet", vpa.Namespace, vpa.Spec.TargetRef.Name)
+	adjust(w, getRecommender(w, vpa))
+}
+
+// adjust applies the recommendations of the recommender to the workload, the outcome is published
+// in the TupyraeRecommendation of the workload
+func adjust(w *Workload, recommender Recommender) {
+	if isIgnored(w.Meta.Annotations) {
+		klog.Infof("Ignoring %s/%s", w.Namespace, w.Name)
 		return
 	}
-	for _, r := range vpa.Status.Recommendation.ContainerRecommendations {
-		for i, c := range deploy.Spec.Template.Spec.Containers {
-			if c.Name == r.ContainerName {
-				if r.LowerBound != nil || r.UpperBound != nil {
-					updatedC := c.DeepCopy()
-					if r.LowerBound != nil && willAdjust(true, &c.Resources.Requests, &r.LowerBound) {
-						updatedC.Resources.Requests = *&r.LowerBound
-						updated = true
-					}
-					if r.UpperBound != nil && willAdjust(false, &c.Resources.Limits, &r.UpperBound) {
-						updatedC.Resources.Limits = *&r.UpperBound
-						updated = true
-					}
-					if updated {
-						deploy.Spec.Template.Spec.Containers[i] = *updatedC
-					}
-				}
-			}
-		}
+
+	policy := getPolicy(w)
+	if policy.Mode == ModeOff {
+		return
 	}
 
-	if updated {
-		klog.Infof("Adjusting Deploy %s/%s: %v %v", vpa.Namespace, vpa.Spec.TargetRef.Name, vpa.Status.Recommendation.ContainerRecommendations[0].LowerBound, vpa.Status.Recommendation.ContainerRecommendations[0].UpperBound)
-		_, err := k8s.UpdateDeploy(deploy)
-		if err != nil {
-			klog.Error(err)
-			return
-		}
-		setCache(vpa)
+	ev := &evaluation{policy: policy, current: w.PodSpec.DeepCopy()}
+	defer publishStatus(w, ev)
+
+	original, planned := plan(w, recommender, ev)
+	if !planned {
+		return
 	}
-}
+	recommendations := ev.recommendations
 
-func cronjobAdjust(vpa *vpav1.VerticalPodAutoscaler) {
-	cronjob, err := k8s.GetCronJob(vpa.Namespace, vpa.Spec.TargetRef.Name)
+	g, err := decide(policy, time.Now())
 	if err != nil {
-		klog.Error(err)
+		klog.Errorf("Invalid maintenance window for %s/%s: %v", w.Namespace, w.Name, err)
+		ev.set(StateBlocked, ReasonWindow, err.Error())
 		return
 	}
 
-	if isIgnored(cronjob.Annotations) {
-		klog.Infof("Ignoring %s/%s", vpa.Namespace, vpa.Spec.TargetRef.Name)
+	switch g {
+	case gateRecommend:
+		recordEvent(w, v1.EventTypeNormal, "Recommended", fmt.Sprintf("from %s: %s", sources(recommendations), describeResources(w.PodSpec)))
+		setCache(w.Namespace, w.Name)
+		ev.set(StateRecommended, ReasonRecommendMode, describeResources(w.PodSpec))
+		ev.cooldown = true
+		return
+	case gateApproval:
+		// The change is applied by ChangeRun once approved, in the maintenance window
+		reason := fmt.Sprintf("Recommended by %s, requests from the %s", sources(recommendations), policy.Recommendation)
+		if err := proposeChange(w, original, reason); err != nil {
+			klog.Errorf("Error proposing the adjustment of %s/%s: %v", w.Namespace, w.Name, err)
+			ev.set(StateFailed, ReasonApproval, err.Error())
+			return
+		}
+		setCache(w.Namespace, w.Name)
+		ev.set(StatePending, ReasonApproval, describeResources(w.PodSpec))
+		ev.cooldown = true
+		return
+	case gateWindow:
+		klog.Infof("Outside of the maintenance window, postponing %s/%s", w.Namespace, w.Name)
+		ev.set(StatePending, ReasonWindow, describeResources(w.PodSpec))
 		return
 	}
 
-	var updated bool = false
-	if vpa.Status.Recommendation == nil || vpa.Status.Recommendation.ContainerRecommendations == nil || len(vpa.Status.Recommendation.ContainerRecommendations) == 0 {
-		klog.Infof("No recommendation for %s/%s yet", vpa.Namespace, vpa.Spec.TargetRef.Name)
+	klog.Infof("Adjusting %s %s/%s from %s: %s", w.Kind, w.Namespace, w.Name, sources(recommendations), describeResources(w.PodSpec))
+	changed, err := commitRevision(w, original)
+	if err != nil {
+		klog.Errorf("Error updating %s: %v", w.Kind, err)
+		ev.set(StateFailed, ReasonOutput, err.Error())
+		ev.adjusted(ResultFailed, err.Error(), 0)
+		auditAdjustment(w, original, recommendations, policy, ResultFailed, err.Error())
+		return
+	}
+	setCache(w.Namespace, w.Name)
+	ev.cooldown = true
+	if !changed {
+		klog.Infof("The %s output already has the resources of %s %s/%s", config.Get().Output, w.Kind, w.Namespace, w.Name)
+		ev.set(StateUpToDate, "", fmt.Sprintf("The %s output already has the resources", config.Get().Output))
 		return
 	}
-	for _, r := range vpa.Status.Recommendation.ContainerRecommendations {
-		for i, c := range cronjob.Spec.JobTemplate.Spec.Template.Spec.Containers {
-			if c.Name == r.ContainerName {
-				if r.LowerBound != nil || r.UpperBound != nil {
-					updatedC := c.DeepCopy()
-					if r.LowerBound != nil && willAdjust(true, &c.Resources.Requests, &r.LowerBound) {
-						updatedC.Resources.Requests = *&r.LowerBound
-						updated = true
-					}
-					if r.UpperBound != nil && willAdjust(false, &c.Resources.Limits, &r.UpperBound) {
-						updatedC.Resources.Limits = *&r.UpperBound
-						updated = true
-					}
-					if updated {
-						cronjob.Spec.JobTemplate.Spec.Template.Spec.Containers[i] = *updatedC
-					}
-				}
+	delta := auditAdjustment(w, original, recommendations, policy, ResultSucceeded, "")
+	ev.current = w.PodSpec
+	ev.set(StateAdjusted, "", describeResources(w.PodSpec))
+	ev.adjusted(ResultSucceeded, fmt.Sprintf("with the %s output", config.Get().Output), delta)
+}
+
+// gate is what becomes of a planned adjustment
+type gate int
+
+const (
+	// gateRecommend only reports the adjustment, in recommend mode
+	gateRecommend gate = iota
+	// gateApproval proposes the adjustment in a TupyraeChange
+	gateApproval
+	// gateWindow postpones the adjustment to the maintenance window
+	gateWindow
+	// gateApply commits the adjustment
+	gateApply
+)
+
+// decide tells what becomes of a planned adjustment under the policy at the time, adjust and the
+// report command sharing it. It fails when the maintenance window is invalid
+func decide(policy Policy, now time.Time) (gate, error) {
+	if policy.Mode == ModeRecommend {
+		return gateRecommend, nil
+	}
+	if policy.Approval {
+		return gateApproval, nil
+	}
+	open, err := inWindow(policy.MaintenanceWindow, now)
+	if err != nil || !open {
+		return gateWindow, err
+	}
+	return gateApply, nil
+}
+
+// plan computes the adjustment of the workload from the recommender into its pod spec and returns
+// the original one. When there is nothing to apply it returns false, the state of the evaluation
+// telling why. Nothing is committed, the report command evaluates the workloads with it too
+func plan(w *Workload, recommender Recommender, ev *evaluation) (*v1.PodSpec, bool) {
+	policy := ev.policy
+
+	// Paused by a revert until the annotation is removed
+	if reason, paused := isPaused(w); paused {
+		ev.set(StateBlocked, ReasonPaused, reason)
+		return nil, false
+	}
+
+	// The VPA updater resizes the pods itself
+	if policy.Mode == ModeAutoVpa {
+		ev.set(StateDelegated, ReasonAutoVpa, "The VPA updater resizes the pods")
+		return nil, false
+	}
+
+	recommendations, err := recommender.Recommend(w)
+	if err != nil {
+		klog.Errorf("Error getting recommendations for %s/%s from %s: %v", w.Namespace, w.Name, recommender.Name(), err)
+		ev.set(StateFailed, ReasonRecommender, err.Error())
+		return nil, false
+	}
+	ev.recommendations = recommendations
+
+	if len(recommendations) == 0 {
+		klog.Infof("No recommendation for %s/%s yet", w.Namespace, w.Name)
+		ev.set(StateNoRecommendation, "", fmt.Sprintf("No recommendation from %s yet", recommender.Name()))
+		return nil, false
+	}
+
+	if r, low := lowConfidence(recommendations, policy); low {
+		klog.Infof("Recommendation for %s/%s container %s is not confident enough yet", w.Namespace, w.Name, r.ContainerName)
+		ev.set(StateBlocked, ReasonConfidence, fmt.Sprintf("Confidence %.2f of container %s is below %.2f", r.Confidence, r.ContainerName, config.Get().MinConfidence))
+		return nil, false
+	}
+
+	resources, ok, err := controlledResources(w, policy)
+	if err != nil {
+		klog.Errorf("Error getting the HPAs of %s/%s: %v", w.Namespace, w.Name, err)
+		ev.set(StateFailed, ReasonHpa, err.Error())
+		return nil, false
+	}
+	if !ok {
+		klog.Infof("Skipping %s %s/%s, it is scaled by an HPA", w.Kind, w.Namespace, w.Name)
+		ev.set(StateBlocked, ReasonHpa, "Scaled by an HPA on CPU or memory")
+		return nil, false
+	}
+
+	overlay(w)
+	original := w.PodSpec.DeepCopy()
+	ev.current = original
+	var updated bool = false
+	for _, r := range recommendations {
+		if policy.isIgnoredContainer(r.ContainerName) {
+			continue
+		}
+
+		c, initContainer := podContainer(w.PodSpec, r.ContainerName, policy)
+		if c == nil {
+			continue
+		}
+
+		// Init containers run to completion before the others start, only their requests are sized
+		if initContainer {
+			target := capResources(filterResources(r.Target, resources), c.Resources.Limits)
+			if policy.adjustRequests() && len(target) > 0 && willAdjust(true, &c.Resources.Requests, &target, policy.Threshold) {
+				c.Resources.Requests = mergeResources(c.Resources.Requests, target)
+				updated = true
 			}
+			continue
+		}
+
+		lower := filterResources(policy.requestsRecommendation(r), resources)
+		upper := filterResources(r.UpperBound, resources)
+		if policy.adjustRequests() && len(lower) > 0 && willAdjust(true, &c.Resources.Requests, &lower, policy.Threshold) {
+			c.Resources.Requests = mergeResources(c.Resources.Requests, lower)
+			updated = true
+		}
+		if policy.adjustLimits() && len(upper) > 0 && willAdjust(false, &c.Resources.Limits, &upper, policy.Threshold) {
+			c.Resources.Limits = mergeResources(c.Resources.Limits, upper)
+			updated = true
 		}
 	}
 
 	if updated {
-		klog.Infof("Adjusting %s/%s: %v %v", vpa.Namespace, vpa.Spec.TargetRef.Name, vpa.Status.Recommendation.ContainerRecommendations[0].LowerBound, vpa.Status.Recommendation.ContainerRecommendations[0].UpperBound)
-		_, err := k8s.UpdateCronJob(cronjob)
-		if err != nil {
-			klog.Errorf("Error updating CronJob: %v", err)
-			return
+		fitNodes(w)
+		if !preserveQos(w, policy, original) {
+			ev.set(StateBlocked, ReasonQos, "The adjustment would change the QoS class")
+			return nil, false
+		}
+		if !validateResources(w, original) {
+			ev.set(StateBlocked, ReasonQuota, "The adjustment violates a LimitRange or exceeds a ResourceQuota")
+			return nil, false
 		}
-		setCache(vpa)
 	}
+
+	// The LimitRanges may have clamped the new resources back to the current ones
+	if !updated || equality.Semantic.DeepEqual(original, w.PodSpec) {
+		ev.set(StateUpToDate, "", "The resources are within the threshold of the recommendation")
+		return nil, false
+	}
+
+	return original, true
 }
 
-func willAdjust(request bool, resource *v1.ResourceList, vpa *v1.ResourceList) bool {
+// filterResources keeps only the given resources of the recommendation
+func filterResources(list v1.ResourceList, resources []v1.ResourceName) v1.ResourceList {
+	filtered := v1.ResourceList{}
+	for name, value := range list {
+		if hasResource(resources, name) {
+			filtered[name] = value
+		}
+	}
+	return filtered
+}
+
+// capResources lowers the recommended values above the limits to the limits
+func capResources(list v1.ResourceList, limits v1.ResourceList) v1.ResourceList {
+	capped := list.DeepCopy()
+	for name, value := range capped {
+		if limit, ok := limits[name]; ok && value.Cmp(limit) > 0 {
+			capped[name] = limit
+		}
+	}
+	return capped
+}
+
+// mergeResources overrides the current values with the recommended ones, keeping the others
+func mergeResources(current v1.ResourceList, recommended v1.ResourceList) v1.ResourceList {
+	merged := current.DeepCopy()
+	if merged == nil {
+		merged = v1.ResourceList{}
+	}
+	for name, value := range recommended {
+		merged[name] = value
+	}
+	return merged
+}
+
+func willAdjust(request bool, resource *v1.ResourceList, vpa *v1.ResourceList, threshold float64) bool {
 
 	if vpa == nil || resource == vpa {
 		return false
 	}
 
-	if request {
-		if request && resource.Cpu().MilliValue() != vpa.Cpu().MilliValue() {
-			return true
-		}
-
-		if !request && resource.Memory().MilliValue() != vpa.Memory().MilliValue() {
-			return true
-		}
-	} else {
-		if outOfLimit(resource.Cpu().MilliValue(), vpa.Cpu().MilliValue()) {
+	for name, value := range *vpa {
+		current := (*resource)[name]
+		if request && current.MilliValue() != value.MilliValue() {
 			return true
 		}
-		if outOfLimit(resource.Memory().MilliValue(), vpa.Memory().MilliValue()) {
+		if !request && outOfLimit(current.MilliValue(), value.MilliValue(), threshold) {
 			return true
 		}
 	}
@@ -191,7 +387,7 @@ func willAdjust(request bool, resource *v1.ResourceList, vpa *v1.ResourceList) b
 	return false
 }
 
-func outOfLimit(resourceValue int64, vpaValue int64) bool {
+func outOfLimit(resourceValue int64, vpaValue int64, threshold float64) bool {
 	diff := 0.0
 	if vpaValue > resourceValue {
 		diff = float64(resourceValue) / float64(vpaValue)
@@ -199,8 +395,8 @@ func outOfLimit(resourceValue int64, vpaValue int64) bool {
 		diff = float64(vpaValue) / float64(resourceValue)
 	}
 	porc := 1 - diff
-	// Check if the difference is greater than 30%
-	if math.Abs(porc) > 0.3 {
+	// Check if the difference is greater than the threshold, 30% by default
+	if math.Abs(porc) > threshold {
 		return true
 	}
 	return false
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// inWindow tells if the time is inside the maintenance window, an empty window is always open.
+// A window is "HH:MM-HH:MM" optionally prefixed by days like "Mon-Fri" or "Sat,Sun", times are
+// in UTC and several windows can be separated by ";"
+func inWindow(window string, now time.Time) (bool, error) {
+	if strings.TrimSpace(window) == "" {
+		return true, nil
+	}
+
+	now = now.UTC()
+	for _, w := range strings.Split(window, ";") {
+		fields := strings.Fields(w)
+		if len(fields) == 0 {
+			continue
+		}
+
+		days := "sun-sat"
+		hours := fields[0]
+		if len(fields) == 2 {
+			days, hours = fields[0], fields[1]
+		} else if len(fields) > 2 {
+			return false, fmt.Errorf("invalid maintenance window %q", w)
+		}
+
+		dayOk, err := matchesDays(days, now.Weekday())
+		if err != nil {
+			return false, err
+		}
+		hourOk, err := matchesHours(hours, now)
+		if err != nil {
+			return false, err
+		}
+		if dayOk && hourOk {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchesDays(days string, day time.Weekday) (bool, error) {
+	for _, item := range strings.Split(strings.ToLower(days), ",") {
+		bounds := strings.SplitN(item, "-", 2)
+		from, ok := weekdays[bounds[0]]
+		if !ok {
+			return false, fmt.Errorf("invalid day %q", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[bounds[1]]; !ok {
+				return false, fmt.Errorf("invalid day %q", bounds[1])
+			}
+		}
+		if (from <= to && day >= from && day <= to) || (from > to && (day >= from || day <= to)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchesHours(hours string, now time.Time) (bool, error) {
+	bounds := strings.SplitN(hours, "-", 2)
+	if len(bounds) != 2 {
+		return false, fmt.Errorf("invalid hours %q", hours)
+	}
+
+	from, err := time.Parse("15:04", bounds[0])
+	if err != nil {
+		return false, err
+	}
+	to, err := time.Parse("15:04", bounds[1])
+	if err != nil {
+		return false, err
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	start, end := from.Hour()*60+from.Minute(), to.Hour()*60+to.Minute()
+	// Windows like 22:00-06:00 span midnight
+	if start <= end {
+		return minute >= start && minute < end, nil
+	}
+	return minute >= start || minute < end, nil
+}
@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"Tupyrae/internal/k8s"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog"
+)
+
+// enabledKey is the annotation or label opting a workload in, even in a namespace not selected
+const enabledKey = "tupyrae/enabled"
+
+// Workload is an object owning a pod template whose resources Tupyrae adjusts
+type Workload struct {
+	Kind       string
+	APIVersion string
+	Name       string
+	Namespace  string
+	Meta       *metav1.ObjectMeta
+	PodSpec    *v1.PodSpec
+	Item       interface{}
+
+	ns *v1.Namespace
+}
+
+func getWorkload(namespace string, kind string, name string) (*Workload, error) {
+	switch kind {
+	case "Deployment":
+		deploy, err := k8s.GetDeploy(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return workloadByDeployment(deploy), nil
+	case "CronJob":
+		cronjob, err := k8s.GetCronJob(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		return workloadByCronJob(cronjob), nil
+	}
+
+	return nil, fmt.Errorf("Unsupported kind: %s", kind)
+}
+
+func workloadByDeployment(deploy *appsv1.Deployment) *Workload {
+	return &Workload{
+		Kind:       "Deployment",
+		APIVersion: "apps/v1",
+		Name:       deploy.Name,
+		Namespace:  deploy.Namespace,
+		Meta:       &deploy.ObjectMeta,
+		PodSpec:    &deploy.Spec.Template.Spec,
+		Item:       deploy,
+	}
+}
+
+func workloadByCronJob(cronjob *batchv1.CronJob) *Workload {
+	return &Workload{
+		Kind:       "CronJob",
+		APIVersion: "batch/v1",
+		Name:       cronjob.Name,
+		Namespace:  cronjob.Namespace,
+		Meta:       &cronjob.ObjectMeta,
+		PodSpec:    &cronjob.Spec.JobTemplate.Spec.Template.Spec,
+		Item:       cronjob,
+	}
+}
+
+func (w *Workload) Update() error {
+	switch item := w.Item.(type) {
+	case *appsv1.Deployment:
+		_, err := k8s.UpdateDeploy(item)
+		return err
+	case *batchv1.CronJob:
+		_, err := k8s.UpdateCronJob(item)
+		return err
+	}
+
+	return fmt.Errorf("Unsupported kind: %s", w.Kind)
+}
+
+// scale returns the number of pods the workload runs and the extra pods created during a rollout
+func (w *Workload) scale() (int64, int64) {
+	switch item := w.Item.(type) {
+	case *appsv1.Deployment:
+		replicas := int64(1)
+		if item.Spec.Replicas != nil {
+			replicas = int64(*item.Spec.Replicas)
+		}
+		if item.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+			return replicas, 0
+		}
+		maxSurge := intstr.FromString("25%")
+		if item.Spec.Strategy.RollingUpdate != nil && item.Spec.Strategy.RollingUpdate.MaxSurge != nil {
+			maxSurge = *item.Spec.Strategy.RollingUpdate.MaxSurge
+		}
+		surge, err := intstr.GetScaledValueFromIntOrPercent(&maxSurge, int(replicas), true)
+		if err != nil {
+			return replicas, 0
+		}
+		return replicas, int64(surge)
+	case *batchv1.CronJob:
+		if item.Spec.JobTemplate.Spec.Parallelism != nil {
+			return int64(*item.Spec.JobTemplate.Spec.Parallelism), 0
+		}
+	}
+
+	return 1, 0
+}
+
+// namespace returns the namespace of the workload, from the informer or fetched once
+func (w *Workload) namespace() *v1.Namespace {
+	if w.ns == nil {
+		if ns, found := namespaces.Get(w.Namespace); found {
+			w.ns = ns.(*v1.Namespace)
+			return w.ns
+		}
+		ns, err := k8s.GetNamespace(w.Namespace)
+		if err != nil {
+			klog.Errorf("Error getting Namespace %s: %v", w.Namespace, err)
+			return nil
+		}
+		w.ns = ns
+	}
+	return w.ns
+}
+
+// workloadMode returns the mode the workload opted in with, if any
+func workloadMode(w *Workload) (string, bool) {
+	value, ok := w.Meta.Annotations[enabledKey]
+	if !ok {
+		value, ok = w.Meta.Labels[enabledKey]
+	}
+	if !ok {
+		return "", false
+	}
+
+	mode, err := parseMode(value)
+	if err != nil {
+		klog.Errorf("%s %s/%s: %v", w.Kind, w.Namespace, w.Name, err)
+	}
+	return mode, true
+}
+
+// checkWorkload syncs the VPA of a managed workload and adjusts the workload from it, or reverts it
+// when asked to
+func checkWorkload(w *Workload) {
+	if _, ok := w.Meta.Annotations[revertKey]; ok {
+		revertWorkload(w)
+		return
+	}
+
+	if !UsesVpa() || !isManaged(w) || w.namespace() == nil {
+		return
+	}
+
+	vpas := mapperVpa(w.namespace())
+	if vpas == nil {
+		return
+	}
+
+	syncVpa(w, vpas)
+
+	if vpa, ok := vpas[getKey(w)]; ok {
+		checkVpa(&vpa)
+	}
+}
+
+func isManaged(w *Workload) bool {
+	if isIgnored(w.Meta.Annotations) {
+		return false
+	}
+
+	return getPolicy(w).Mode != ModeOff
+}
+
+func findContainer(containers []v1.Container, name string) *v1.Container {
+	for i := range containers {
+		if containers[i].Name == name {
+			return &containers[i]
+		}
+	}
+	return nil
+}
+
+// podContainer returns the container or init container the recommendation is for, according to
+// the policy, and whether it is a classic init container rather than a native sidecar
+func podContainer(spec *v1.PodSpec, name string, policy Policy) (*v1.Container, bool) {
+	if c := findContainer(spec.Containers, name); c != nil {
+		return c, false
+	}
+
+	c := findContainer(spec.InitContainers, name)
+	if c == nil {
+		return nil, false
+	}
+
+	sidecar := isSidecar(c)
+	switch policy.InitContainers {
+	case InitContainersAll:
+		return c, !sidecar
+	case InitContainersSidecars:
+		if sidecar {
+			return c, false
+		}
+	}
+
+	return nil, false
+}
+
+// isSidecar tells if the init container is a native sidecar, running along the other containers
+func isSidecar(c *v1.Container) bool {
+	return c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways
+}
+
+// allContainers returns the init containers and containers of the pod
+func allContainers(spec *v1.PodSpec) []*v1.Container {
+	var containers []*v1.Container
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	return containers
+}
+
+// podResources computes the effective requests and limits of the pod like the scheduler: the
+// containers and sidecars run together, each init container runs with the sidecars started before
+// it, the requests include the pod overhead
+func podResources(spec *v1.PodSpec) (v1.ResourceList, v1.ResourceList) {
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+	for _, c := range spec.Containers {
+		addResources(requests, c.Resources.Requests)
+		addResources(limits, c.Resources.Limits)
+	}
+
+	sidecarRequests, sidecarLimits := v1.ResourceList{}, v1.ResourceList{}
+	for i := range spec.InitContainers {
+		c := &spec.InitContainers[i]
+		if isSidecar(c) {
+			addResources(requests, c.Resources.Requests)
+			addResources(limits, c.Resources.Limits)
+			addResources(sidecarRequests, c.Resources.Requests)
+			addResources(sidecarLimits, c.Resources.Limits)
+			continue
+		}
+
+		initRequests, initLimits := sidecarRequests.DeepCopy(), sidecarLimits.DeepCopy()
+		addResources(initRequests, c.Resources.Requests)
+		addResources(initLimits, c.Resources.Limits)
+		maxResources(requests, initRequests)
+		maxResources(limits, initLimits)
+	}
+
+	addResources(requests, spec.Overhead)
+	return requests, limits
+}
+
+func addResources(total v1.ResourceList, list v1.ResourceList) {
+	for name, value := range list {
+		sum := total[name]
+		sum.Add(value)
+		total[name] = sum
+	}
+}
+
+func maxResources(total v1.ResourceList, list v1.ResourceList) {
+	for name, value := range list {
+		if current, ok := total[name]; !ok || value.Cmp(current) > 0 {
+			total[name] = value
+		}
+	}
+}
+
+// describeResources formats the requests and limits of the pod containers for logs and events
+func describeResources(spec *v1.PodSpec) string {
+	var parts []string
+	for _, c := range allContainers(spec) {
+		parts = append(parts, fmt.Sprintf("%s requests cpu=%s memory=%s limits cpu=%s memory=%s", c.Name,
+			c.Resources.Requests.Cpu().String(), c.Resources.Requests.Memory().String(),
+			c.Resources.Limits.Cpu().String(), c.Resources.Limits.Memory().String()))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,106 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+)
+
+// ChangeResource is the TupyraeChange custom resource, an adjustment waiting for approval
+var ChangeResource = schema.GroupVersionResource{Group: "tupyrae.io", Version: "v1alpha1", Resource: "tupyraechanges"}
+
+type TupyraeChange struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TupyraeChangeSpec   `json:"spec"`
+	Status TupyraeChangeStatus `json:"status,omitempty"`
+}
+
+type TupyraeChangeSpec struct {
+	WorkloadRef WorkloadRef `json:"workloadRef"`
+	// Containers are the resources of the containers before and after the adjustment
+	Containers []ContainerChange `json:"containers"`
+	// Reason explains where the new resources come from
+	Reason string `json:"reason,omitempty"`
+	// Approved is set by a human to let Tupyrae apply the change
+	Approved bool `json:"approved,omitempty"`
+}
+
+type WorkloadRef struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+type ContainerChange struct {
+	Name   string                      `json:"name"`
+	Before corev1.ResourceRequirements `json:"before"`
+	After  corev1.ResourceRequirements `json:"after"`
+}
+
+type TupyraeChangeStatus struct {
+	// Phase is Pending, Applied, Expired, Superseded, Outdated or Failed
+	Phase     string       `json:"phase,omitempty"`
+	Message   string       `json:"message,omitempty"`
+	AppliedAt *metav1.Time `json:"appliedAt,omitempty"`
+	// FinishedAt is when the change stopped being pending
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+}
+
+func GetChanges(namespace string) ([]TupyraeChange, error) {
+	list, err := GetDynamicClient().Resource(ChangeResource).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]TupyraeChange, len(list.Items))
+	for i, item := range list.Items {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &changes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func CreateChange(change *TupyraeChange) error {
+	klog.Infof("Creating TupyraeChange %s/%s", change.Namespace, change.Name)
+
+	change.APIVersion = ChangeResource.GroupVersion().String()
+	change.Kind = "TupyraeChange"
+	obj, err := toUnstructured(change)
+	if err != nil {
+		return err
+	}
+	_, err = GetDynamicClient().Resource(ChangeResource).Namespace(change.Namespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+	return err
+}
+
+func UpdateChangeStatus(change *TupyraeChange) error {
+	klog.Infof("Updating status of TupyraeChange %s/%s to %s", change.Namespace, change.Name, change.Status.Phase)
+
+	obj, err := toUnstructured(change)
+	if err != nil {
+		return err
+	}
+	_, err = GetDynamicClient().Resource(ChangeResource).Namespace(change.Namespace).UpdateStatus(context.TODO(), obj, metav1.UpdateOptions{})
+	return err
+}
+
+func DeleteChange(namespace string, name string) error {
+	klog.Infof("Deleting TupyraeChange %s/%s", namespace, name)
+	return GetDynamicClient().Resource(ChangeResource).Namespace(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+}
+
+func toUnstructured(obj interface{}) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
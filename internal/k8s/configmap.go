@@ -0,0 +1,33 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+func GetConfigMap(namespace string, name string) (*corev1.ConfigMap, error) {
+	return GetClient().CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func GetConfigMaps(namespace string, selector string) ([]corev1.ConfigMap, error) {
+	resp, err := GetClient().CoreV1().ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
+
+func CreateConfigMap(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	klog.Infof("Creating ConfigMap %s/%s", cm.Namespace, cm.Name)
+
+	return GetClient().CoreV1().ConfigMaps(cm.Namespace).Create(context.TODO(), cm, metav1.CreateOptions{})
+}
+
+func UpdateConfigMap(cm *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	klog.Infof("Updating ConfigMap %s/%s", cm.Namespace, cm.Name)
+
+	return GetClient().CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+}
@@ -5,6 +5,7 @@ import (
 
 	batchv1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 )
 
@@ -26,3 +27,7 @@ func UpdateCronJob(cronjob *batchv1.CronJob) (*batchv1.CronJob, error) {
 	klog.Infof("Updating CronJob %s", cronjob.Name)
 	return GetClient().BatchV1().CronJobs(cronjob.Namespace).Update(context.TODO(), cronjob, metav1.UpdateOptions{})
 }
+
+func PatchCronJob(namespace string, name string, patch []byte) (*batchv1.CronJob, error) {
+	return GetClient().BatchV1().CronJobs(namespace).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
@@ -5,6 +5,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/klog/v2"
 )
 
@@ -36,3 +37,7 @@ func UpdateDeploy(deploy *appsv1.Deployment) (*appsv1.Deployment, error) {
 
 	return deploy, nil
 }
+
+func PatchDeploy(namespace string, name string, patch []byte) (*appsv1.Deployment, error) {
+	return GetClient().AppsV1().Deployments(namespace).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+}
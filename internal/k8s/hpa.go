@@ -0,0 +1,16 @@
+package k8s
+
+import (
+	"context"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func GetHpas(namespace string) ([]autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpas, err := GetClient().AutoscalingV2().HorizontalPodAutoscalers(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return hpas.Items, nil
+}
@@ -0,0 +1,20 @@
+package k8s
+
+import (
+	"context"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func GetJob(namespace string, name string) (*batchv1.Job, error) {
+	return GetClient().BatchV1().Jobs(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func GetJobs(namespace string) ([]batchv1.Job, error) {
+	resp, err := GetClient().BatchV1().Jobs(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
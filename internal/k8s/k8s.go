@@ -4,13 +4,16 @@ import (
 	"os"
 
 	autoscalingv1beta2 "k8s.io/autoscaler/vertical-pod-autoscaler/pkg/client/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 )
 
 var client *kubernetes.Clientset
 var clientAutoscaling *autoscalingv1beta2.Clientset
+var clientDynamic *dynamic.DynamicClient
 
 func GetClient() *kubernetes.Clientset {
 	if client == nil {
@@ -45,11 +48,39 @@ func GetAutoscalerClient() *autoscalingv1beta2.Clientset {
 	return clientAutoscaling
 }
 
+func GetDynamicClient() *dynamic.DynamicClient {
+	if clientDynamic == nil {
+		config, err := getClientConfig()
+		if err != nil {
+			return nil
+		}
+
+		cli, err := dynamic.NewForConfig(config)
+		if err != nil {
+			return nil
+		}
+		clientDynamic = cli
+	}
+
+	return clientDynamic
+}
+
+// kubeconfig is the kubeconfig file of the CLI, empty for the default loading rules
+var kubeconfig string
+var useKubeconfig bool
+
+// UseKubeconfig makes the clients use the kubeconfig file instead of the in-cluster config
+func UseKubeconfig(path string) {
+	kubeconfig = path
+	useKubeconfig = true
+}
+
 func getClientConfig() (*rest.Config, error) {
-	// if !isRunningInContainer() {
-	// 	klog.Info("Running locally, using kubeconfig")
-	// 	return clientcmd.BuildConfigFromFlags("", "/Users/digode/.kube/config")
-	// }
+	if useKubeconfig {
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		rules.ExplicitPath = kubeconfig
+		return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	}
 
 	klog.Info("Running in container, using in-cluster config")
 	return rest.InClusterConfig()
@@ -0,0 +1,12 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func GetNamespace(name string) (*corev1.Namespace, error) {
+	return GetClient().CoreV1().Namespaces().Get(context.TODO(), name, metav1.GetOptions{})
+}
@@ -0,0 +1,25 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+func GetPods(namespace string, selector string) ([]corev1.Pod, error) {
+	resp, err := GetClient().CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
+
+// ResizePod patches the resources of the running pod through the resize subresource
+func ResizePod(namespace string, name string, patch []byte) (*corev1.Pod, error) {
+	klog.Infof("Resizing Pod %s/%s", namespace, name)
+
+	return GetClient().CoreV1().Pods(namespace).Patch(context.TODO(), name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "resize")
+}
@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RecommendationResource is the TupyraeRecommendation custom resource, what Tupyrae thinks of a workload
+var RecommendationResource = schema.GroupVersionResource{Group: "tupyrae.io", Version: "v1alpha1", Resource: "tupyraerecommendations"}
+
+type TupyraeRecommendation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TupyraeRecommendationSpec   `json:"spec"`
+	Status TupyraeRecommendationStatus `json:"status,omitempty"`
+}
+
+type TupyraeRecommendationSpec struct {
+	WorkloadRef WorkloadRef `json:"workloadRef"`
+}
+
+type TupyraeRecommendationStatus struct {
+	// State is the outcome of the last evaluation: Adjusted, Recommended, Pending, Blocked, UpToDate,
+	// Delegated, NoRecommendation or Failed
+	State string `json:"state,omitempty"`
+	// Reason tells why the adjustment is pending or blocked
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+
+	Containers     []ContainerStatus `json:"containers,omitempty"`
+	Policy         PolicyStatus      `json:"policy,omitempty"`
+	Source         string            `json:"source,omitempty"`
+	LastEvaluation *metav1.Time      `json:"lastEvaluation,omitempty"`
+	// CooldownUntil is the time before which the workload is not evaluated again
+	CooldownUntil  *metav1.Time      `json:"cooldownUntil,omitempty"`
+	LastAdjustment *AdjustmentStatus `json:"lastAdjustment,omitempty"`
+	Cost           *CostStatus       `json:"cost,omitempty"`
+}
+
+type ContainerStatus struct {
+	Name       string                      `json:"name"`
+	Resources  corev1.ResourceRequirements `json:"resources,omitempty"`
+	LowerBound corev1.ResourceList         `json:"lowerBound,omitempty"`
+	Target     corev1.ResourceList         `json:"target,omitempty"`
+	UpperBound corev1.ResourceList         `json:"upperBound,omitempty"`
+	// Confidence of the recommendation, from 0 to 1
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+type PolicyStatus struct {
+	Mode              string   `json:"mode,omitempty"`
+	Output            string   `json:"output,omitempty"`
+	Resources         []string `json:"resources,omitempty"`
+	Values            string   `json:"values,omitempty"`
+	Recommendation    string   `json:"recommendation,omitempty"`
+	Threshold         float64  `json:"threshold,omitempty"`
+	Hpa               string   `json:"hpa,omitempty"`
+	Qos               string   `json:"qos,omitempty"`
+	MaintenanceWindow string   `json:"maintenanceWindow,omitempty"`
+	Approval          bool     `json:"approval,omitempty"`
+}
+
+type AdjustmentStatus struct {
+	Time    metav1.Time `json:"time"`
+	Result  string      `json:"result"`
+	Message string      `json:"message,omitempty"`
+	// HourlyCostDelta is the change of the hourly cost of the requests, negative when saving
+	HourlyCostDelta float64 `json:"hourlyCostDelta,omitempty"`
+}
+
+// CostStatus is the hourly cost of the requests of the workload pods
+type CostStatus struct {
+	Pool       string  `json:"pool,omitempty"`
+	Replicas   int64   `json:"replicas"`
+	HourlyCost float64 `json:"hourlyCost"`
+}
+
+func GetRecommendation(namespace string, name string) (*TupyraeRecommendation, error) {
+	obj, err := GetDynamicClient().Resource(RecommendationResource).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &TupyraeRecommendation{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// CreateRecommendation creates the resource and returns it with its resource version, for the status update
+func CreateRecommendation(rec *TupyraeRecommendation) (*TupyraeRecommendation, error) {
+	rec.APIVersion = RecommendationResource.GroupVersion().String()
+	rec.Kind = "TupyraeRecommendation"
+	obj, err := toUnstructured(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := GetDynamicClient().Resource(RecommendationResource).Namespace(rec.Namespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	rec.ResourceVersion = created.GetResourceVersion()
+	rec.UID = created.GetUID()
+	return rec, nil
+}
+
+func UpdateRecommendationStatus(rec *TupyraeRecommendation) error {
+	obj, err := toUnstructured(rec)
+	if err != nil {
+		return err
+	}
+	_, err = GetDynamicClient().Resource(RecommendationResource).Namespace(rec.Namespace).UpdateStatus(context.TODO(), obj, metav1.UpdateOptions{})
+	return err
+}
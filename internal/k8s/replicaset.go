@@ -0,0 +1,12 @@
+package k8s
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func GetReplicaSet(namespace string, name string) (*appsv1.ReplicaSet, error) {
+	return GetClient().AppsV1().ReplicaSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
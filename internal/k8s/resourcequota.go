@@ -0,0 +1,16 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func GetResourceQuotas(namespace string) ([]corev1.ResourceQuota, error) {
+	resp, err := GetClient().CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Items, nil
+}
@@ -0,0 +1,25 @@
+package k8s
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+func GetSecret(namespace string, name string) (*corev1.Secret, error) {
+	return GetClient().CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func CreateSecret(secret *corev1.Secret) (*corev1.Secret, error) {
+	klog.Infof("Creating Secret %s/%s", secret.Namespace, secret.Name)
+
+	return GetClient().CoreV1().Secrets(secret.Namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+}
+
+func UpdateSecret(secret *corev1.Secret) (*corev1.Secret, error) {
+	klog.Infof("Updating Secret %s/%s", secret.Namespace, secret.Name)
+
+	return GetClient().CoreV1().Secrets(secret.Namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+}
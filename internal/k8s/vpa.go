@@ -35,3 +35,9 @@ func CreateVpa(vpa *v1.VerticalPodAutoscaler) (*v1.VerticalPodAutoscaler, error)
 
 	return vpa, nil
 }
+
+func UpdateVpa(vpa *v1.VerticalPodAutoscaler) (*v1.VerticalPodAutoscaler, error) {
+	klog.Infof("Updating VPA %s", vpa.Name)
+
+	return GetAutoscalerClient().AutoscalingV1().VerticalPodAutoscalers(vpa.Namespace).Update(context.TODO(), vpa, metav1.UpdateOptions{})
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+)
+
+// Metric is a family of samples told apart by the values of its labels
+type Metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	value  func() float64
+
+	mu      sync.Mutex
+	samples map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*Metric
+)
+
+func register(m *Metric) *Metric {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+	return m
+}
+
+// NewGauge registers a metric whose samples are set to any value
+func NewGauge(name string, help string, labels ...string) *Metric {
+	return register(&Metric{name: name, help: help, kind: typeGauge, labels: labels, samples: map[string]*sample{}})
+}
+
+// NewCounter registers a metric whose samples only increase
+func NewCounter(name string, help string, labels ...string) *Metric {
+	return register(&Metric{name: name, help: help, kind: typeCounter, labels: labels, samples: map[string]*sample{}})
+}
+
+// NewGaugeFunc registers a gauge without labels computed at each scrape
+func NewGaugeFunc(name string, help string, value func() float64) *Metric {
+	return register(&Metric{name: name, help: help, kind: typeGauge, value: value})
+}
+
+func (m *Metric) get(values []string) *sample {
+	if len(values) != len(m.labels) {
+		klog.Errorf("Metric %s expects %d labels, got %d", m.name, len(m.labels), len(values))
+		return nil
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.samples[key]
+	if !ok {
+		s = &sample{labels: append([]string{}, values...)}
+		m.samples[key] = s
+	}
+	return s
+}
+
+// Set sets the sample of the label values, gauges only
+func (m *Metric) Set(value float64, values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.get(values); s != nil {
+		s.value = value
+	}
+}
+
+// Add adds to the sample of the label values, counters ignore negative values
+func (m *Metric) Add(value float64, values ...string) {
+	if m.kind == typeCounter && value < 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.get(values); s != nil {
+		s.value += value
+	}
+}
+
+// Delete removes the sample of the label values
+func (m *Metric) Delete(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.samples, strings.Join(values, "\xff"))
+}
+
+// Sum returns the total of the samples
+func (m *Metric) Sum() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	total := 0.0
+	for _, s := range m.samples {
+		total += s.value
+	}
+	return total
+}
+
+// write prints the metric in the Prometheus text format, the samples sorted for stable scrapes
+func (m *Metric) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	if m.value != nil {
+		fmt.Fprintf(w, "%s %s\n", m.name, formatValue(m.value()))
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.samples))
+	for key := range m.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.samples[key]
+		pairs := make([]string, len(m.labels))
+		for i, label := range m.labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, label, labelEscaper.Replace(s.labels[i]))
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", m.name, strings.Join(pairs, ","), formatValue(s.value))
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Handler serves the registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w := bufio.NewWriter(rw)
+		registryMu.Lock()
+		metrics := append([]*Metric{}, registry...)
+		registryMu.Unlock()
+		for _, m := range metrics {
+			m.write(w)
+		}
+		w.Flush()
+	})
+}
+
+// Serve exposes the metrics on /metrics
+func Serve(port int) {
+	klog.Infof("Serving metrics on :%d", port)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		klog.Errorf("Metrics server stopped: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client queries a Prometheus compatible HTTP API
+type Client struct {
+	URL  string
+	HTTP *http.Client
+}
+
+// Sample is an element of an instant vector
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+type response struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			Value  []interface{}     `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		URL:  strings.TrimRight(url, "/"),
+		HTTP: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Query evaluates an instant query returning a vector
+func (c *Client) Query(ctx context.Context, query string) ([]Sample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"/api/v1/query?"+url.Values{"query": {query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding response (HTTP %d): %v", resp.StatusCode, err)
+	}
+
+	if body.Status != "success" {
+		return nil, fmt.Errorf("query failed (HTTP %d): %s", resp.StatusCode, body.Error)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("query failed (HTTP %d)", resp.StatusCode)
+	}
+
+	if body.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("unexpected result type %s", body.Data.ResultType)
+	}
+
+	samples := make([]Sample, 0, len(body.Data.Result))
+	for _, r := range body.Data.Result {
+		if len(r.Value) != 2 {
+			return nil, fmt.Errorf("invalid sample %v", r.Value)
+		}
+		raw, ok := r.Value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid sample value %v", r.Value[1])
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, Sample{Labels: r.Metric, Value: value})
+	}
+
+	return samples, nil
+}
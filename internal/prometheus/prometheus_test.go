@@ -0,0 +1,122 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stub serves the body with the status code to every query and keeps the last query received
+func stub(t *testing.T, code int, body string, query *string) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if query != nil {
+			*query = r.URL.Query().Get("query")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewClient(server.URL + "/")
+}
+
+func TestQuery(t *testing.T) {
+	var query string
+	client := stub(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[
+		{"metric":{"container":"app"},"value":[1700000000,"0.25"]},
+		{"metric":{"container":"sidecar"},"value":[1700000000,"1048576"]}]}}`, &query)
+
+	samples, err := client.Query(context.Background(), `up{job="x"}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if query != `up{job="x"}` {
+		t.Errorf("query = %q, want %q", query, `up{job="x"}`)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(samples))
+	}
+	if samples[0].Labels["container"] != "app" || samples[0].Value != 0.25 {
+		t.Errorf("samples[0] = %+v", samples[0])
+	}
+	if samples[1].Labels["container"] != "sidecar" || samples[1].Value != 1048576 {
+		t.Errorf("samples[1] = %+v", samples[1])
+	}
+}
+
+func TestQueryEmptyVector(t *testing.T) {
+	client := stub(t, http.StatusOK, `{"status":"success","data":{"resultType":"vector","result":[]}}`, nil)
+
+	samples, err := client.Query(context.Background(), "up")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(samples) != 0 {
+		t.Errorf("got %d samples, want none", len(samples))
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		body string
+		want string
+	}{
+		{
+			name: "api error",
+			code: http.StatusBadRequest,
+			body: `{"status":"error","errorType":"bad_data","error":"parse error at char 3"}`,
+			want: "parse error at char 3",
+		},
+		{
+			name: "api error with 200",
+			code: http.StatusOK,
+			body: `{"status":"error","error":"query timed out"}`,
+			want: "query timed out",
+		},
+		{
+			name: "non 2xx without body",
+			code: http.StatusBadGateway,
+			body: `<html>Bad Gateway</html>`,
+			want: "HTTP 502",
+		},
+		{
+			name: "non 2xx with success body",
+			code: http.StatusServiceUnavailable,
+			body: `{"status":"success","data":{"resultType":"vector","result":[]}}`,
+			want: "HTTP 503",
+		},
+		{
+			name: "matrix result",
+			code: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"matrix","result":[]}}`,
+			want: "unexpected result type matrix",
+		},
+		{
+			name: "invalid value",
+			code: http.StatusOK,
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,12]}]}}`,
+			want: "invalid sample value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := stub(t, tt.code, tt.body, nil)
+
+			_, err := client.Query(context.Background(), "up")
+			if err == nil {
+				t.Fatalf("expected an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
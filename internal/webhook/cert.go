@@ -0,0 +1,152 @@
+package webhook
+
+import (
+	"Tupyrae/internal/k8s"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// certValidity is long enough to never expire while kept in the Secret, an expired one is issued again
+const certValidity = 10 * 365 * 24 * time.Hour
+
+// caBundleKey holds the PEM encoded CA in the Secret, next to the tls.crt and tls.key of the serving certificate
+const caBundleKey = "ca.crt"
+
+// loadCerts returns the CA and the serving certificate kept in the Secret, issued and stored on the first
+// start. The restarts and the replicas share them, so the CA bundle of the webhook configuration stays valid
+func loadCerts(namespace string, name string, dnsName string) ([]byte, tls.Certificate, error) {
+	secret, err := k8s.GetSecret(namespace, name)
+	switch {
+	case errors.IsNotFound(err):
+		secret = nil
+	case err != nil:
+		return nil, tls.Certificate{}, err
+	default:
+		caBundle, cert, err := parseCerts(secret, dnsName)
+		if err == nil {
+			return caBundle, cert, nil
+		}
+		klog.Warningf("Issuing the webhook certificates again, Secret %s/%s: %v", namespace, name, err)
+	}
+
+	caBundle, certPem, keyPem, err := generateCerts(dnsName)
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	data := map[string][]byte{caBundleKey: caBundle, corev1.TLSCertKey: certPem, corev1.TLSPrivateKeyKey: keyPem}
+	if secret == nil {
+		_, err = k8s.CreateSecret(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Type:       corev1.SecretTypeTLS,
+			Data:       data,
+		})
+	} else {
+		secret.Data = data
+		_, err = k8s.UpdateSecret(secret)
+	}
+
+	// Another replica stored its certificates first, they are the ones to use
+	if errors.IsAlreadyExists(err) || errors.IsConflict(err) {
+		if secret, err = k8s.GetSecret(namespace, name); err != nil {
+			return nil, tls.Certificate{}, err
+		}
+		return parseCerts(secret, dnsName)
+	}
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	return caBundle, cert, err
+}
+
+// parseCerts reads the certificates of the Secret, they must be valid for the DNS name
+func parseCerts(secret *corev1.Secret, dnsName string) ([]byte, tls.Certificate, error) {
+	caBundle := secret.Data[caBundleKey]
+	if len(caBundle) == 0 {
+		return nil, tls.Certificate{}, fmt.Errorf("no %s", caBundleKey)
+	}
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return nil, tls.Certificate{}, fmt.Errorf("certificate expired on %s", leaf.NotAfter)
+	}
+	if err := leaf.VerifyHostname(dnsName); err != nil {
+		return nil, tls.Certificate{}, err
+	}
+	return caBundle, cert, nil
+}
+
+// generateCerts issues a self-signed CA and a serving certificate for the DNS name, it returns
+// the PEM encoded CA for the webhook configuration and the serving certificate and key
+func generateCerts(dnsName string) ([]byte, []byte, []byte, error) {
+	now := time.Now()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tupyrae-webhook-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return encodePem("CERTIFICATE", caDer), encodePem("CERTIFICATE", der), encodePem("EC PRIVATE KEY", keyDer), nil
+}
+
+func encodePem(blockType string, der []byte) []byte {
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: blockType, Bytes: der})
+	return buf.Bytes()
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"Tupyrae/internal/config"
+	"Tupyrae/internal/handler"
+	"Tupyrae/internal/k8s"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Serve runs the mutating admission webhook setting the resources of the new pods, it loads its
+// certificate from the Secret, issuing it the first time, and registers the CA in the
+// MutatingWebhookConfiguration first
+func Serve() {
+	klog.Infof("Starting admission webhook...")
+
+	cfg := config.Get()
+	namespace, name, ok := strings.Cut(cfg.WebhookSecret, "/")
+	if !ok {
+		klog.Fatalf("Invalid webhook Secret %q, expected namespace/name", cfg.WebhookSecret)
+	}
+	caBundle, cert, err := loadCerts(namespace, name, cfg.WebhookService)
+	if err != nil {
+		klog.Fatalf("Error loading the webhook certificates: %v", err)
+	}
+
+	if err := registerCA(cfg.WebhookConfiguration, caBundle); err != nil {
+		klog.Fatalf("Error registering the webhook CA: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/mutate", mutate)
+	server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", cfg.WebhookPort),
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+	if err := server.ListenAndServeTLS("", ""); err != nil {
+		klog.Fatalf("Admission webhook stopped: %v", err)
+	}
+}
+
+// registerCA sets the CA bundle of every webhook of the configuration, left alone when already set
+func registerCA(name string, caBundle []byte) error {
+	webhook, err := k8s.GetMutatingWebhookConfiguration(name)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i := range webhook.Webhooks {
+		if !bytes.Equal(webhook.Webhooks[i].ClientConfig.CABundle, caBundle) {
+			webhook.Webhooks[i].ClientConfig.CABundle = caBundle
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	_, err = k8s.UpdateMutatingWebhookConfiguration(webhook)
+	return err
+}
+
+func mutate(rw http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(rw, "invalid AdmissionReview", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = admit(review.Request)
+	review.Request = nil
+	resp, err := json.Marshal(review)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(resp)
+}
+
+// admit always allows the pod, a failure only leaves its resources as they are
+func admit(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{UID: req.UID, Allowed: true}
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+		resp.Result = &metav1.Status{Message: err.Error()}
+		return resp
+	}
+	// The namespace of a pod being created is only in the request
+	pod.Namespace = req.Namespace
+
+	patch, err := handler.MutatePod(pod)
+	if err != nil {
+		klog.Errorf("Error mutating a pod of %s: %v", req.Namespace, err)
+		resp.Result = &metav1.Status{Message: err.Error()}
+		return resp
+	}
+
+	if patch != nil {
+		patchType := admissionv1.PatchTypeJSONPatch
+		resp.Patch = patch
+		resp.PatchType = &patchType
+	}
+	return resp
+}